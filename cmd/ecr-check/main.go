@@ -2,12 +2,16 @@ package main
 
 import (
 	"fmt"
+	"io/ioutil"
 	"log"
 	"os"
-	"regexp"
+	"strconv"
 	"strings"
 
+	cred "github.com/carash/ecs-deploy/credential"
 	"github.com/carash/ecs-deploy/ecr"
+	"github.com/carash/ecs-deploy/output"
+	"github.com/carash/ecs-deploy/signals"
 	"github.com/urfave/cli"
 )
 
@@ -16,6 +20,10 @@ var (
 	build   = "0"
 )
 
+// exitCanceled is the exit status when the check was interrupted by SIGINT or
+// SIGTERM.
+const exitCanceled = 130
+
 func main() {
 	app := cli.NewApp()
 	app.Name = "AWS ECS Deploy"
@@ -33,19 +41,64 @@ func main() {
 			Usage:  "AWS secret key",
 			EnvVar: "PLUGIN_SECRET_KEY,ECS_SECRET_KEY,AWS_SECRET_ACCESS_KEY",
 		},
+		cli.StringFlag{
+			Name:   "session-token",
+			Usage:  "AWS session token, for temporary keys",
+			EnvVar: "PLUGIN_SESSION_TOKEN,AWS_SESSION_TOKEN",
+		},
+		cli.StringFlag{
+			Name:   "web-identity-role-arn",
+			Usage:  "AWS role to assume with a web identity (OIDC) token",
+			EnvVar: "PLUGIN_WEB_IDENTITY_ROLE_ARN,AWS_ROLE_ARN",
+		},
+		cli.StringFlag{
+			Name:   "web-identity-token",
+			Usage:  "Web identity (OIDC) token",
+			EnvVar: "PLUGIN_WEB_IDENTITY_TOKEN",
+		},
+		cli.StringFlag{
+			Name:   "web-identity-token-file",
+			Usage:  "File containing the web identity (OIDC) token",
+			EnvVar: "PLUGIN_WEB_IDENTITY_TOKEN_FILE,AWS_WEB_IDENTITY_TOKEN_FILE",
+		},
 		cli.StringFlag{
 			Name:   "assume-role-arn",
-			Usage:  "AWS secret key",
+			Usage:  "AWS role to assume",
 			EnvVar: "PLUGIN_ASSUME_ROLE_ARN",
 		},
+		cli.StringFlag{
+			Name:   "assume-role-external-id",
+			Usage:  "External ID required by the assumed role",
+			EnvVar: "PLUGIN_ASSUME_ROLE_EXTERNAL_ID",
+		},
+		cli.StringFlag{
+			Name:   "assume-role-session-name",
+			Usage:  "Session name of the assumed role",
+			EnvVar: "PLUGIN_ASSUME_ROLE_SESSION_NAME",
+		},
+		cli.Int64Flag{
+			Name:   "assume-role-duration",
+			Usage:  "Duration of the assumed role session in seconds, defaults to 15 minutes",
+			EnvVar: "PLUGIN_ASSUME_ROLE_DURATION",
+		},
 		cli.StringFlag{
 			Name:   "aws-region",
 			Usage:  "aws region",
 			EnvVar: "PLUGIN_AWS_REGION,AWS_DEFAULT_REGION",
 		},
 		cli.StringFlag{
+			Name:   "ecr-endpoint",
+			Usage:  "Custom AWS ECR endpoint",
+			EnvVar: "PLUGIN_ECR_ENDPOINT",
+		},
+		cli.StringFlag{
+			Name:   "sts-endpoint",
+			Usage:  "Custom AWS STS endpoint",
+			EnvVar: "PLUGIN_STS_ENDPOINT",
+		},
+		cli.StringSliceFlag{
 			Name:   "ecr-image",
-			Usage:  "Full URL of the image",
+			Usage:  "Full URL of the image, repeated or comma separated to wait for several images at once",
 			EnvVar: "PLUGIN_IMAGE",
 		},
 		cli.Int64Flag{
@@ -58,6 +111,65 @@ func main() {
 			Usage:  "Timeout when checking availability of image, defaults to 60 seconds",
 			EnvVar: "PLUGIN_TIMEOUT",
 		},
+		cli.BoolFlag{
+			Name:   "scan",
+			Usage:  "Wait for the scan findings of the image and fail when they exceed scan-max-findings, implied by the other scan flags",
+			EnvVar: "PLUGIN_SCAN",
+		},
+		cli.BoolFlag{
+			Name:   "scan-trigger",
+			Usage:  "Start a scan of the image instead of waiting for the scan on push",
+			EnvVar: "PLUGIN_SCAN_TRIGGER",
+		},
+		cli.StringSliceFlag{
+			Name:   "scan-max-findings",
+			Usage:  "Number of findings allowed per severity as SEVERITY=N, defaults to CRITICAL=0",
+			EnvVar: "PLUGIN_SCAN_MAX_FINDINGS",
+		},
+		cli.StringFlag{
+			Name:   "scan-allowlist-file",
+			Usage:  "File of accepted finding names such as CVE IDs, one per line",
+			EnvVar: "PLUGIN_SCAN_ALLOWLIST_FILE",
+		},
+		cli.Int64Flag{
+			Name:   "scan-timeout",
+			Usage:  "Timeout when waiting for the scan to complete, defaults to 300 seconds",
+			EnvVar: "PLUGIN_SCAN_TIMEOUT",
+		},
+		cli.StringFlag{
+			Name:   "scan-summary",
+			Usage:  "Format of the findings summary: table or json, defaults to table",
+			EnvVar: "PLUGIN_SCAN_SUMMARY",
+		},
+		cli.StringFlag{
+			Name:   "log-format",
+			Usage:  "Format of the progress messages: text or json, defaults to text",
+			EnvVar: "PLUGIN_LOG_FORMAT",
+		},
+		cli.StringFlag{
+			Name:   "output",
+			Usage:  "Format of the result document: text or json. The json result is written to stdout unless output-file is set",
+			EnvVar: "PLUGIN_OUTPUT",
+		},
+		cli.StringFlag{
+			Name:   "output-file",
+			Usage:  "File to write the json result document to",
+			EnvVar: "PLUGIN_OUTPUT_FILE",
+		},
+	}
+	app.Commands = []cli.Command{
+		{
+			Name:   "promote",
+			Usage:  "Tag the image given by ecr-image as target-image, copying it to the target repository if needed",
+			Action: promote,
+			Flags: []cli.Flag{
+				cli.StringFlag{
+					Name:   "target-image",
+					Usage:  "Full URL of the image to write, with its tag",
+					EnvVar: "PLUGIN_TARGET_IMAGE",
+				},
+			},
+		},
 	}
 	if err := app.Run(os.Args); err != nil {
 		log.Fatal(err)
@@ -65,32 +177,32 @@ func main() {
 }
 
 func run(c *cli.Context) error {
-	creds := ecr.Credential{}
-	if c.IsSet("access-key") {
-		s := c.String("access-key")
-		creds.AWSAccessKeyID = &s
-	}
-	if c.IsSet("secret-key") {
-		s := c.String("secret-key")
-		creds.AWSSecretAccessKey = &s
-	}
-	if c.IsSet("assume-role-arn") {
-		s := c.String("assume-role-arn")
-		creds.AWSAssumeRoleARN = &s
-	}
-	if c.IsSet("aws-region") {
-		s := c.String("aws-region")
-		creds.AWSRegion = &s
+	out, err := output.NewLogger(c)
+	if err != nil {
+		return err
 	}
 
-	image, err := parseECRImage(c.String("ecr-image"))
+	images, err := parseECRImages(c.StringSlice("ecr-image"))
 	if err != nil {
 		return err
 	}
 
 	plugin := ecr.ImagePlugin{
-		AWSCredential: creds,
-		Image:         *image,
+		AWSCredential: cred.FromContext(c, images[0].Region),
+		Logger:        out,
+		Image:         images[0],
+	}
+	if len(images) > 1 {
+		plugin.Images = images
+	}
+	if scanRequested(c) {
+		if len(images) > 1 {
+			return fmt.Errorf("Only a single image can be scanned")
+		}
+		plugin.Scan, err = newScanPolicy(c)
+		if err != nil {
+			return err
+		}
 	}
 
 	var interval, timeout int64
@@ -106,32 +218,157 @@ func run(c *cli.Context) error {
 		timeout = 60
 	}
 
-	return plugin.WaitForImage(interval, timeout)
+	ctx, stop := signals.Context(out)
+	defer stop()
+
+	err = plugin.WaitForImageWithContext(ctx, interval, timeout)
+	if err == nil && plugin.Scan != nil {
+		scanTimeout := int64(300)
+		if c.IsSet("scan-timeout") {
+			scanTimeout = c.Int64("scan-timeout")
+		}
+		err = plugin.ScanImageWithContext(ctx, interval, scanTimeout)
+	}
+	var result interface{} = plugin.Result
+	if plugin.ImagesResult != nil {
+		result = plugin.ImagesResult
+	}
+	if err := output.WriteResult(c, result); err != nil {
+		return err
+	}
+	if err != nil && ctx.Err() != nil {
+		return cli.NewExitError(err.Error(), exitCanceled)
+	}
+
+	return err
+}
+
+func promote(c *cli.Context) error {
+	out, err := output.NewLogger(c)
+	if err != nil {
+		return err
+	}
+
+	images, err := parseECRImages(c.GlobalStringSlice("ecr-image"))
+	if err != nil {
+		return err
+	}
+	if len(images) > 1 {
+		return fmt.Errorf("Only a single image can be promoted")
+	}
+	image := &images[0]
+	target, err := parseECRImage(c.String("target-image"))
+	if err != nil {
+		return err
+	}
+
+	plugin := ecr.ImagePlugin{
+		AWSCredential: cred.FromContext(c, image.Region),
+		Logger:        out,
+		Image:         *image,
+		Target:        target,
+	}
+
+	ctx, stop := signals.Context(out)
+	defer stop()
+
+	err = plugin.PromoteWithContext(ctx)
+	if err := output.WriteResult(c, plugin.Result); err != nil {
+		return err
+	}
+	if err != nil && ctx.Err() != nil {
+		return cli.NewExitError(err.Error(), exitCanceled)
+	}
+
+	return err
+}
+
+// The helpers below read the global flags, so they can be shared by the
+// default action and the subcommands.
+
+// scanRequested is true with --scan, or any of the flags that only apply to
+// a scan.
+func scanRequested(c *cli.Context) bool {
+	for _, name := range []string{"scan-trigger", "scan-max-findings", "scan-allowlist-file", "scan-timeout", "scan-summary"} {
+		if c.IsSet(name) {
+			return true
+		}
+	}
+
+	return c.Bool("scan")
+}
+
+// newScanPolicy reads the thresholds as SEVERITY=N and the allowlist file,
+// which holds a finding name per line and # comments.
+func newScanPolicy(c *cli.Context) (*ecr.ScanPolicy, error) {
+	policy := &ecr.ScanPolicy{
+		Trigger:     c.Bool("scan-trigger"),
+		MaxFindings: map[string]int64{"CRITICAL": 0},
+		Summary:     c.String("scan-summary"),
+	}
+
+	if values := c.StringSlice("scan-max-findings"); len(values) > 0 {
+		policy.MaxFindings = map[string]int64{}
+		for _, v := range values {
+			parts := strings.SplitN(v, "=", 2)
+			if len(parts) != 2 {
+				return nil, fmt.Errorf("Bad maximum findings [%s], must be SEVERITY=N", v)
+			}
+			max, err := strconv.ParseInt(strings.TrimSpace(parts[1]), 10, 64)
+			if err != nil {
+				return nil, fmt.Errorf("Bad maximum findings [%s], must be SEVERITY=N", v)
+			}
+			policy.MaxFindings[strings.ToUpper(strings.TrimSpace(parts[0]))] = max
+		}
+	}
+
+	if path := c.String("scan-allowlist-file"); path != "" {
+		b, err := ioutil.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("Allowlist file [%s] cannot be read: %s", path, err)
+		}
+		for _, line := range strings.Split(string(b), "\n") {
+			if i := strings.Index(line, "#"); i >= 0 {
+				line = line[:i]
+			}
+			if line = strings.TrimSpace(line); line != "" {
+				policy.Allowlist = append(policy.Allowlist, line)
+			}
+		}
+	}
+
+	return policy, nil
+}
+
+// parseECRImages parses the images to wait for, at least one. Commas split
+// the flag values as they do the environment variable.
+func parseECRImages(values []string) ([]ecr.Image, error) {
+	uris := []string{}
+	for _, v := range values {
+		uris = append(uris, strings.Split(v, ",")...)
+	}
+	if len(uris) == 0 {
+		uris = []string{""}
+	}
+
+	images := []ecr.Image{}
+	for _, uri := range uris {
+		image, err := parseECRImage(strings.TrimSpace(uri))
+		if err != nil {
+			return nil, err
+		}
+		images = append(images, *image)
+	}
+
+	return images, nil
 }
 
-var imageRegex, _ = regexp.Compile(`^\d{12}.dkr.ecr.[a-z]{2}-[a-z]+-\d{1,2}.amazonaws.com\/[\w-]+$`)
-var taggedRegex, _ = regexp.Compile(`^\d{12}.dkr.ecr.[a-z]{2}-[a-z]+-\d{1,2}.amazonaws.com\/[\w-]+:[\w-]+$`)
-
-func parseECRImage(image string) (*ecr.Image, error) {
-	switch true {
-	case imageRegex.MatchString(image):
-		registry := image[:12]
-		repository := strings.Split(image, "/")[1]
-		return &ecr.Image{
-			RegistryId:     &registry,
-			RepositoryName: repository,
-		}, nil
-	case taggedRegex.MatchString(image):
-		registry := image[:12]
-		repotag := strings.Split(image, "/")[1]
-		repository := strings.Split(repotag, ":")[0]
-		tag := strings.Split(repotag, ":")[1]
-		return &ecr.Image{
-			RegistryId:     &registry,
-			RepositoryName: repository,
-			ImageTags:      &[]*string{&tag},
-		}, nil
-	}
-
-	return nil, fmt.Errorf("Bad ECR Image Registry")
+func parseECRImage(uri string) (*ecr.Image, error) {
+	ref, err := ecr.ParseReference(uri)
+	if err != nil {
+		return nil, err
+	}
+
+	image := ref.Image()
+	return &image, nil
 }
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"encoding/json"
 	"fmt"
 	"log"
 	"os"
@@ -8,9 +9,12 @@ import (
 	"strconv"
 	"strings"
 
+	"github.com/aws/aws-sdk-go/aws"
 	awsecs "github.com/aws/aws-sdk-go/service/ecs"
 	cred "github.com/carash/ecs-deploy/credential"
 	"github.com/carash/ecs-deploy/ecs"
+	"github.com/carash/ecs-deploy/output"
+	"github.com/carash/ecs-deploy/signals"
 	"github.com/urfave/cli"
 )
 
@@ -19,6 +23,18 @@ var (
 	build   = "0"
 )
 
+const (
+	// exitRolledBack is the exit status when a failed deployment was rolled back.
+	exitRolledBack = 2
+	// exitTaskFailed is the exit status when the container of run-task exited
+	// with a non-zero code. The code itself is only reported in the result and
+	// the exit-code output, since it could match any of the statuses here.
+	exitTaskFailed = 3
+	// exitCanceled is the exit status when the deploy was interrupted by
+	// SIGINT or SIGTERM.
+	exitCanceled = 130
+)
+
 func main() {
 	app := cli.NewApp()
 	app.Name = "AWS ECS Deploy"
@@ -36,16 +52,76 @@ func main() {
 			Usage:  "AWS secret key",
 			EnvVar: "PLUGIN_SECRET_KEY,ECS_SECRET_KEY,AWS_SECRET_ACCESS_KEY",
 		},
+		cli.StringFlag{
+			Name:   "session-token",
+			Usage:  "AWS session token, for temporary keys",
+			EnvVar: "PLUGIN_SESSION_TOKEN,AWS_SESSION_TOKEN",
+		},
+		cli.StringFlag{
+			Name:   "web-identity-role-arn",
+			Usage:  "AWS role to assume with a web identity (OIDC) token",
+			EnvVar: "PLUGIN_WEB_IDENTITY_ROLE_ARN,AWS_ROLE_ARN",
+		},
+		cli.StringFlag{
+			Name:   "web-identity-token",
+			Usage:  "Web identity (OIDC) token",
+			EnvVar: "PLUGIN_WEB_IDENTITY_TOKEN",
+		},
+		cli.StringFlag{
+			Name:   "web-identity-token-file",
+			Usage:  "File containing the web identity (OIDC) token",
+			EnvVar: "PLUGIN_WEB_IDENTITY_TOKEN_FILE,AWS_WEB_IDENTITY_TOKEN_FILE",
+		},
 		cli.StringFlag{
 			Name:   "assume-role-arn",
-			Usage:  "AWS secret key",
+			Usage:  "AWS role to assume",
 			EnvVar: "PLUGIN_ASSUME_ROLE_ARN",
 		},
+		cli.StringFlag{
+			Name:   "assume-role-external-id",
+			Usage:  "External ID required by the assumed role",
+			EnvVar: "PLUGIN_ASSUME_ROLE_EXTERNAL_ID",
+		},
+		cli.StringFlag{
+			Name:   "assume-role-session-name",
+			Usage:  "Session name of the assumed role",
+			EnvVar: "PLUGIN_ASSUME_ROLE_SESSION_NAME",
+		},
+		cli.Int64Flag{
+			Name:   "assume-role-duration",
+			Usage:  "Duration of the assumed role session in seconds, defaults to 15 minutes",
+			EnvVar: "PLUGIN_ASSUME_ROLE_DURATION",
+		},
 		cli.StringFlag{
 			Name:   "aws-region",
 			Usage:  "aws region",
 			EnvVar: "PLUGIN_AWS_REGION,AWS_DEFAULT_REGION",
 		},
+		cli.StringFlag{
+			Name:   "ecs-endpoint",
+			Usage:  "Custom AWS ECS endpoint",
+			EnvVar: "PLUGIN_ECS_ENDPOINT",
+		},
+		cli.StringFlag{
+			Name:   "ecr-endpoint",
+			Usage:  "Custom AWS ECR endpoint",
+			EnvVar: "PLUGIN_ECR_ENDPOINT",
+		},
+		cli.StringFlag{
+			Name:   "sts-endpoint",
+			Usage:  "Custom AWS STS endpoint",
+			EnvVar: "PLUGIN_STS_ENDPOINT",
+		},
+		cli.StringFlag{
+			Name:   "logs-endpoint",
+			Usage:  "Custom AWS CloudWatch Logs endpoint",
+			EnvVar: "PLUGIN_LOGS_ENDPOINT",
+		},
+		cli.StringFlag{
+			Name:   "ssm-endpoint",
+			Usage:  "Custom AWS SSM endpoint",
+			EnvVar: "PLUGIN_SSM_ENDPOINT",
+		},
 		cli.StringFlag{
 			Name:   "cluster",
 			Usage:  "AWS ECS cluster",
@@ -71,6 +147,26 @@ func main() {
 			Usage:  "Number of seconds to hold off health checks",
 			EnvVar: "PLUGIN_HEALTH_CHECK_GRACE_PREIOD",
 		},
+		cli.StringFlag{
+			Name:   "service-file",
+			Usage:  "JSON or YAML file describing the Service, in the same shape as create-service --cli-input-json",
+			EnvVar: "PLUGIN_SERVICE_FILE",
+		},
+		cli.BoolFlag{
+			Name:   "create-if-missing",
+			Usage:  "Create the Service when it does not exist yet",
+			EnvVar: "PLUGIN_CREATE_IF_MISSING",
+		},
+		cli.StringFlag{
+			Name:   "task-definition-file",
+			Usage:  "JSON or YAML file describing the Task Definition, in the same shape as register-task-definition --cli-input-json",
+			EnvVar: "PLUGIN_TASK_DEFINITION_FILE",
+		},
+		cli.BoolFlag{
+			Name:   "delete-container",
+			Usage:  "Remove containers of the previous Task Definition revision that are not listed",
+			EnvVar: "PLUGIN_DELETE_CONTAINER",
+		},
 		cli.StringFlag{
 			Name:   "container-name",
 			Usage:  "Container name",
@@ -81,25 +177,264 @@ func main() {
 			Usage:  "image to use",
 			EnvVar: "PLUGIN_IMAGE",
 		},
+		cli.StringSliceFlag{
+			Name:   "container-image",
+			Usage:  "Image of a container, as name=image. Repeat it to update several containers in one revision",
+			EnvVar: "PLUGIN_CONTAINER_IMAGES",
+		},
+		cli.BoolFlag{
+			Name:   "add-container",
+			Usage:  "Add the containers named by container-image that are not in the previous revision, instead of failing",
+			EnvVar: "PLUGIN_ADD_CONTAINER",
+		},
+		cli.BoolFlag{
+			Name:   "resolve-image-digests",
+			Usage:  "Register ECR images by the digest their tag points at, recording the tag in the ecs-deploy.original-image docker label",
+			EnvVar: "PLUGIN_RESOLVE_IMAGE_DIGESTS",
+		},
+		cli.BoolFlag{
+			Name:   "wait-for-images",
+			Usage:  "Wait for the ECR images of the containers to be pushed before registering the Task Definition",
+			EnvVar: "PLUGIN_WAIT_FOR_IMAGES",
+		},
+		cli.Int64Flag{
+			Name:   "image-wait-interval",
+			Usage:  "Interval to check availability of the images, defaults to 10 seconds",
+			EnvVar: "PLUGIN_IMAGE_WAIT_INTERVAL",
+		},
+		cli.Int64Flag{
+			Name:   "image-wait-timeout",
+			Usage:  "Timeout when waiting for the images, defaults to 300 seconds",
+			EnvVar: "PLUGIN_IMAGE_WAIT_TIMEOUT",
+		},
+		cli.StringFlag{
+			Name:   "container-definitions",
+			Usage:  "JSON array of Container Definitions, replacing the containers of the same name",
+			EnvVar: "PLUGIN_CONTAINER_DEFINITIONS",
+		},
+		cli.StringSliceFlag{
+			Name:   "environment-variables",
+			Usage:  "Environment variables of the container, as KEY=VALUE or a JSON object",
+			EnvVar: "PLUGIN_ENVIRONMENT_VARIABLES",
+		},
+		cli.StringSliceFlag{
+			Name:   "secrets",
+			Usage:  "Secrets of the container, as NAME=valueFrom or a JSON object",
+			EnvVar: "PLUGIN_SECRETS",
+		},
+		cli.StringSliceFlag{
+			Name:   "merge-environment-variables",
+			Usage:  "Environment variables set by name on top of those of the previous revision, as KEY=VALUE or a JSON object",
+			EnvVar: "PLUGIN_MERGE_ENVIRONMENT_VARIABLES",
+		},
+		cli.StringSliceFlag{
+			Name:   "unset-environment-variables",
+			Usage:  "Environment variables removed from the previous revision",
+			EnvVar: "PLUGIN_UNSET_ENVIRONMENT_VARIABLES",
+		},
+		cli.StringFlag{
+			Name:   "environment-file",
+			Usage:  "Dotenv file of environment variables merged into those of the previous revision",
+			EnvVar: "PLUGIN_ENVIRONMENT_FILE",
+		},
+		cli.StringSliceFlag{
+			Name:   "merge-secrets",
+			Usage:  "Secrets set by name on top of those of the previous revision, as NAME=valueFrom or a JSON object",
+			EnvVar: "PLUGIN_MERGE_SECRETS",
+		},
+		cli.StringSliceFlag{
+			Name:   "unset-secrets",
+			Usage:  "Secrets removed from the previous revision",
+			EnvVar: "PLUGIN_UNSET_SECRETS",
+		},
+		cli.StringFlag{
+			Name:   "secrets-path",
+			Usage:  "SSM Parameter Store path whose parameters are merged into the Secrets of the previous revision",
+			EnvVar: "PLUGIN_SECRETS_PATH",
+		},
+		cli.Int64Flag{
+			Name:   "container-cpu",
+			Usage:  "CPU units reserved for the container",
+			EnvVar: "PLUGIN_CONTAINER_CPU",
+		},
+		cli.Int64Flag{
+			Name:   "container-memory",
+			Usage:  "Hard memory limit of the container in MiB",
+			EnvVar: "PLUGIN_CONTAINER_MEMORY",
+		},
+		cli.Int64Flag{
+			Name:   "container-memory-reservation",
+			Usage:  "Soft memory limit of the container in MiB",
+			EnvVar: "PLUGIN_CONTAINER_MEMORY_RESERVATION",
+		},
+		cli.StringSliceFlag{
+			Name:   "port-mappings",
+			Usage:  "Port mappings of the container, as [hostPort:]containerPort[/protocol]",
+			EnvVar: "PLUGIN_PORT_MAPPINGS",
+		},
+		cli.StringFlag{
+			Name:   "log-driver",
+			Usage:  "Log driver of the container, such as awslogs",
+			EnvVar: "PLUGIN_LOG_DRIVER",
+		},
+		cli.StringSliceFlag{
+			Name:   "log-options",
+			Usage:  "Options of the log driver, as KEY=VALUE or a JSON object",
+			EnvVar: "PLUGIN_LOG_OPTIONS",
+		},
+		cli.StringFlag{
+			Name:   "task-cpu",
+			Usage:  "CPU of the Task, required by Fargate",
+			EnvVar: "PLUGIN_TASK_CPU",
+		},
+		cli.StringFlag{
+			Name:   "task-memory",
+			Usage:  "Memory of the Task, required by Fargate",
+			EnvVar: "PLUGIN_TASK_MEMORY",
+		},
+		cli.StringFlag{
+			Name:   "task-role-arn",
+			Usage:  "IAM role the containers of the Task assume",
+			EnvVar: "PLUGIN_TASK_ROLE_ARN",
+		},
+		cli.StringFlag{
+			Name:   "execution-role-arn",
+			Usage:  "IAM role ECS uses to pull images and read secrets",
+			EnvVar: "PLUGIN_EXECUTION_ROLE_ARN",
+		},
+		cli.StringSliceFlag{
+			Name:   "subnets",
+			Usage:  "Subnets of the awsvpc network configuration",
+			EnvVar: "PLUGIN_SUBNETS",
+		},
+		cli.StringSliceFlag{
+			Name:   "security-groups",
+			Usage:  "Security groups of the awsvpc network configuration",
+			EnvVar: "PLUGIN_SECURITY_GROUPS",
+		},
+		cli.BoolFlag{
+			Name:   "assign-public-ip",
+			Usage:  "Assign a public IP in the awsvpc network configuration",
+			EnvVar: "PLUGIN_ASSIGN_PUBLIC_IP",
+		},
+		cli.BoolFlag{
+			Name:   "dry-run",
+			Usage:  "Print the changes to the Task Definition and Service without applying them",
+			EnvVar: "PLUGIN_DRY_RUN",
+		},
+		cli.BoolFlag{
+			Name:   "rollback-on-failure",
+			Usage:  "Roll the Service back to its previous Task Definition when the deployment fails",
+			EnvVar: "PLUGIN_ROLLBACK_ON_FAILURE",
+		},
+		cli.BoolFlag{
+			Name:   "rollback-on-cancel",
+			Usage:  "Start a rollback to the previous Task Definition when interrupted by SIGINT or SIGTERM",
+			EnvVar: "PLUGIN_ROLLBACK_ON_CANCEL",
+		},
+		cli.StringFlag{
+			Name:   "wait-strategy",
+			Usage:  "How to wait for the deployment: health-status, deployment or steady-state, defaults to health-status",
+			EnvVar: "PLUGIN_WAIT_STRATEGY",
+		},
+		cli.Int64Flag{
+			Name:   "max-failed-tasks",
+			Usage:  "Fail the deployment once this many tasks of the new Task Definition have stopped, disabled by default",
+			EnvVar: "PLUGIN_MAX_FAILED_TASKS",
+		},
+		cli.Int64Flag{
+			Name:   "prune-keep",
+			Usage:  "Keep this many recent revisions of the Task Definition, plus those in use, and deregister the others after a successful deploy",
+			EnvVar: "PLUGIN_PRUNE_KEEP",
+		},
+		cli.BoolFlag{
+			Name:   "prune-delete-inactive",
+			Usage:  "Also delete the INACTIVE revisions of the Task Definition when pruning",
+			EnvVar: "PLUGIN_PRUNE_DELETE_INACTIVE",
+		},
 		cli.Int64Flag{
 			Name:   "timeout",
 			Usage:  "Timeout to wait for healthy check",
 			EnvVar: "PLUGIN_TIMEOUT",
 		},
+		cli.StringFlag{
+			Name:   "log-format",
+			Usage:  "Format of the progress messages: text or json, defaults to text",
+			EnvVar: "PLUGIN_LOG_FORMAT",
+		},
+		cli.StringFlag{
+			Name:   "output",
+			Usage:  "Format of the result document: text or json. The json result is written to stdout unless output-file is set",
+			EnvVar: "PLUGIN_OUTPUT",
+		},
+		cli.StringFlag{
+			Name:   "output-file",
+			Usage:  "File to write the json result document to",
+			EnvVar: "PLUGIN_OUTPUT_FILE",
+		},
+	}
+	app.Commands = []cli.Command{
+		{
+			Name:   "run-task",
+			Usage:  "Run a one-off Task, such as a migration, and fail when its container exits with a non-zero code",
+			Action: runTask,
+			Flags: []cli.Flag{
+				cli.StringFlag{
+					Name:   "command",
+					Usage:  "Command override for the Task's container, as a JSON array or a space separated string",
+					EnvVar: "PLUGIN_RUN_COMMAND",
+				},
+				cli.StringSliceFlag{
+					Name:   "environment",
+					Usage:  "Environment override for the Task's container, as KEY=VALUE",
+					EnvVar: "PLUGIN_RUN_ENVIRONMENT",
+				},
+				cli.StringFlag{
+					Name:   "started-by",
+					Usage:  "Tag the Task with who started it",
+					EnvVar: "PLUGIN_STARTED_BY",
+				},
+			},
+		},
+		{
+			Name:   "prune",
+			Usage:  "Deregister the old revisions of a Task Definition that no Service of the cluster uses",
+			Action: prune,
+			Flags: []cli.Flag{
+				cli.StringFlag{
+					Name:   "family",
+					Usage:  "Task Definition family to prune, defaults to the one of the Service",
+					EnvVar: "PLUGIN_FAMILY",
+				},
+			},
+		},
 	}
+	githubActionsInputs(app)
 	if err := app.Run(os.Args); err != nil {
 		log.Fatal(err)
 	}
 }
 
 func run(c *cli.Context) error {
-	creds := cred.Credential{}
-	creds.AWSAccessKeyID = c.String("access-key")
-	creds.AWSSecretAccessKey = c.String("secret-key")
-	creds.AWSAssumeRoleARN = c.String("assume-role-arn")
-	creds.AWSRegion = c.String("aws-region")
+	out, err := output.NewLogger(c)
+	if err != nil {
+		return err
+	}
+	creds := cred.FromContext(c, "")
 
-	service := ecs.Service{Service: c.String("service")}
+	service := &ecs.Service{}
+	if c.IsSet("service-file") {
+		s, err := ecs.LoadService(c.String("service-file"))
+		if err != nil {
+			return err
+		}
+		service = s
+	}
+	if c.IsSet("service") {
+		service.Service = c.String("service")
+	}
+	service.DryRun = c.Bool("dry-run")
+	service.CreateIfMissing = c.Bool("create-if-missing")
 	if c.IsSet("cluster") {
 		s := c.String("cluster")
 		service.Cluster = &s
@@ -127,30 +462,294 @@ func run(c *cli.Context) error {
 		service.HealthCheckGracePeriodSeconds = &i
 	}
 
-	if c.IsSet("container-name") || c.IsSet("docker-image") {
-		task := ecs.TaskDefinition{}
+	task, err := newTaskDefinition(c, service.TaskDefinition)
+	if err != nil {
+		return err
+	}
+	service.TaskDefinition = task
+	service.NetworkConfiguration = newNetworkConfiguration(c, service.NetworkConfiguration)
 
-		container := ecs.ContainerDefinition{Name: c.String("container-name")}
-		if c.IsSet("docker-image") {
-			s := c.String("docker-image")
-			container.Image = &s
+	plugin := ecs.ServicePlugin{
+		AWSCredential:     creds,
+		Logger:            out,
+		Service:           *service,
+		RollbackOnFailure: c.Bool("rollback-on-failure"),
+		RollbackOnCancel:  c.Bool("rollback-on-cancel"),
+		WaitStrategy:      c.String("wait-strategy"),
+		MaxFailedTasks:    c.Int64("max-failed-tasks"),
+	}
+	if c.IsSet("prune-keep") {
+		if c.Int64("prune-keep") < 1 {
+			return fmt.Errorf("Prune must keep at least 1 revision")
+		}
+		plugin.Prune = &ecs.Prune{
+			Keep:           c.Int64("prune-keep"),
+			DeleteInactive: c.Bool("prune-delete-inactive"),
 		}
+	}
 
-		task.ContainerDefinitions = []*ecs.ContainerDefinition{&container}
-		service.TaskDefinition = &task
+	ctx, stop := signals.Context(out)
+	defer stop()
+
+	err = plugin.UpdateServiceWithContext(ctx, timeout(c))
+	if err := output.WriteResult(c, plugin.Result); err != nil {
+		return err
+	}
+	if err := writeGitHubOutput([]keyValue{
+		{"status", plugin.Result.Status},
+		{"task-definition-arn", plugin.Result.TaskDefinitionArn},
+		{"previous-task-definition-arn", plugin.Result.PreviousTaskDefinitionArn},
+		{"deployment-id", plugin.Result.DeploymentId},
+		{"prune-error", pruneError(plugin.Result.Prune)},
+	}); err != nil {
+		return err
+	}
+	if err != nil && ctx.Err() != nil {
+		return cli.NewExitError(err.Error(), exitCanceled)
+	}
+	if _, ok := err.(*ecs.RollbackError); ok {
+		return cli.NewExitError(err.Error(), exitRolledBack)
 	}
 
-	plugin := ecs.ServicePlugin{
-		AWSCredential: creds,
-		Service:       service,
+	return err
+}
+
+func runTask(c *cli.Context) error {
+	out, err := output.NewLogger(c)
+	if err != nil {
+		return err
+	}
+
+	task := ecs.Task{ContainerName: c.GlobalString("container-name")}
+	if c.GlobalIsSet("cluster") {
+		s := c.GlobalString("cluster")
+		task.Cluster = &s
+	}
+	if c.GlobalIsSet("service") {
+		s := c.GlobalString("service")
+		task.Service = &s
+	}
+	if c.IsSet("command") {
+		command, err := parseCommand(c.String("command"))
+		if err != nil {
+			return err
+		}
+		task.Command = command
+	}
+	for _, e := range c.StringSlice("environment") {
+		kv := strings.SplitN(e, "=", 2)
+		if len(kv) != 2 {
+			return fmt.Errorf("Environment [%s] must be KEY=VALUE", e)
+		}
+		task.Environment = append(task.Environment, &awsecs.KeyValuePair{Name: &kv[0], Value: &kv[1]})
+	}
+	task.NetworkConfiguration = newNetworkConfiguration(c, nil)
+	if c.IsSet("started-by") {
+		s := c.String("started-by")
+		task.StartedBy = &s
+	}
+
+	td, err := newTaskDefinition(c, nil)
+	if err != nil {
+		return err
+	}
+	if td == nil {
+		td = &ecs.TaskDefinition{}
+	}
+	td.DryRun = c.GlobalBool("dry-run")
+
+	plugin := ecs.TaskPlugin{
+		AWSCredential:  cred.FromContext(c, ""),
+		Logger:         out,
+		TaskDefinition: *td,
+		Task:           task,
+	}
+
+	ctx, stop := signals.Context(out)
+	defer stop()
+
+	code, err := plugin.RunTaskWithContext(ctx, timeout(c))
+	if err := output.WriteResult(c, plugin.Result); err != nil {
+		return err
+	}
+	exitCode := ""
+	if plugin.Result.ExitCode != nil {
+		exitCode = strconv.FormatInt(*plugin.Result.ExitCode, 10)
+	}
+	if err := writeGitHubOutput([]keyValue{
+		{"status", plugin.Result.Status},
+		{"task-arn", plugin.Result.TaskArn},
+		{"task-definition-arn", plugin.Result.TaskDefinitionArn},
+		{"exit-code", exitCode},
+	}); err != nil {
+		return err
+	}
+	if err != nil && ctx.Err() != nil {
+		return cli.NewExitError(err.Error(), exitCanceled)
+	}
+	if err != nil {
+		return err
+	}
+	if code != 0 {
+		return cli.NewExitError(fmt.Sprintf("Task exited with code %d", code), exitTaskFailed)
+	}
+
+	return nil
+}
+
+func prune(c *cli.Context) error {
+	out, err := output.NewLogger(c)
+	if err != nil {
+		return err
+	}
+
+	pr := ecs.Prune{
+		Family:         c.String("family"),
+		Keep:           10,
+		DeleteInactive: c.GlobalBool("prune-delete-inactive"),
+		DryRun:         c.GlobalBool("dry-run"),
+	}
+	if c.GlobalIsSet("prune-keep") {
+		pr.Keep = c.GlobalInt64("prune-keep")
+	}
+	if c.GlobalIsSet("cluster") {
+		s := c.GlobalString("cluster")
+		pr.Cluster = &s
+	}
+	if c.GlobalIsSet("service") {
+		s := c.GlobalString("service")
+		pr.Service = &s
 	}
 
-	var timeout int64
-	if c.IsSet("timeout") {
-		timeout = c.Int64("timeout")
+	plugin := ecs.PrunePlugin{
+		AWSCredential: cred.FromContext(c, ""),
+		Logger:        out,
+		Prune:         pr,
+	}
+
+	ctx, stop := signals.Context(out)
+	defer stop()
+
+	err = plugin.PruneTaskDefinitionsWithContext(ctx)
+	if err := output.WriteResult(c, plugin.Result); err != nil {
+		return err
+	}
+	if err != nil && ctx.Err() != nil {
+		return cli.NewExitError(err.Error(), exitCanceled)
+	}
+
+	return err
+}
+
+// The helpers below read the global flags, so they can be shared by the
+// default action and the subcommands.
+
+// newTaskDefinition applies the task definition flags on top of task, which
+// may be nil when no Task Definition was given yet.
+func newTaskDefinition(c *cli.Context, task *ecs.TaskDefinition) (*ecs.TaskDefinition, error) {
+	if c.GlobalIsSet("task-definition-file") {
+		t, err := ecs.LoadTaskDefinition(c.GlobalString("task-definition-file"))
+		if err != nil {
+			return nil, err
+		}
+
+		task = t
+	}
+
+	if c.GlobalIsSet("container-definitions") {
+		if task == nil {
+			task = &ecs.TaskDefinition{}
+		}
+		if err := setContainerDefinitions(c, task); err != nil {
+			return nil, err
+		}
+	}
+
+	if c.GlobalIsSet("container-name") || anySet(c, containerFlags) {
+		if task == nil {
+			task = &ecs.TaskDefinition{}
+		}
+
+		var container *ecs.ContainerDefinition
+		for _, cd := range task.ContainerDefinitions {
+			if cd != nil && cd.Name == c.GlobalString("container-name") {
+				container = cd
+			}
+		}
+		if container == nil {
+			container = &ecs.ContainerDefinition{Name: c.GlobalString("container-name")}
+			task.ContainerDefinitions = append(task.ContainerDefinitions, container)
+		}
+
+		if err := setContainer(c, container); err != nil {
+			return nil, err
+		}
+	}
+
+	if c.GlobalIsSet("container-image") {
+		kvs, err := keyValues(c, "container-image")
+		if err != nil {
+			return nil, err
+		}
+		if task == nil {
+			task = &ecs.TaskDefinition{}
+		}
+		if task.ContainerImages == nil {
+			task.ContainerImages = map[string]string{}
+		}
+		seen := map[string]bool{}
+		for _, kv := range kvs {
+			if seen[kv.Key] {
+				return nil, fmt.Errorf("Container [%s] is given more than one image", kv.Key)
+			}
+			seen[kv.Key] = true
+			task.ContainerImages[kv.Key] = kv.Value
+		}
+	}
+
+	if anySet(c, taskFlags) {
+		if task == nil {
+			task = &ecs.TaskDefinition{}
+		}
+		setTask(c, task)
+	}
+
+	if c.GlobalIsSet("delete-container") && task != nil {
+		task.DeleteContainer = c.GlobalBool("delete-container")
+	}
+	if c.GlobalIsSet("add-container") && task != nil {
+		task.AddContainer = c.GlobalBool("add-container")
+	}
+	if c.GlobalIsSet("resolve-image-digests") && task != nil {
+		task.ResolveImageDigests = c.GlobalBool("resolve-image-digests")
+	}
+	if c.GlobalBool("wait-for-images") && task != nil {
+		task.WaitForImages = &ecs.ImageWait{
+			Interval: c.GlobalInt64("image-wait-interval"),
+			Timeout:  c.GlobalInt64("image-wait-timeout"),
+		}
+	}
+
+	return task, nil
+}
+
+func timeout(c *cli.Context) int64 {
+	if c.GlobalIsSet("timeout") {
+		return c.GlobalInt64("timeout")
+	}
+
+	return 600
+}
+
+func parseCommand(command string) ([]*string, error) {
+	args := []string{}
+	if strings.HasPrefix(strings.TrimSpace(command), "[") {
+		if err := json.Unmarshal([]byte(command), &args); err != nil {
+			return nil, fmt.Errorf("Command cannot be parsed: %s", err)
+		}
 	} else {
-		timeout = 600
+		args = strings.Fields(command)
 	}
 
-	return plugin.UpdateService(timeout)
+	return aws.StringSlice(args), nil
 }
@@ -0,0 +1,416 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	awsecs "github.com/aws/aws-sdk-go/service/ecs"
+	"github.com/carash/ecs-deploy/ecs"
+	"github.com/urfave/cli"
+)
+
+// containerFlags are applied to the container named by container-name.
+var containerFlags = []string{
+	"docker-image",
+	"environment-variables",
+	"secrets",
+	"merge-environment-variables",
+	"unset-environment-variables",
+	"environment-file",
+	"merge-secrets",
+	"unset-secrets",
+	"secrets-path",
+	"container-cpu",
+	"container-memory",
+	"container-memory-reservation",
+	"port-mappings",
+	"log-driver",
+	"log-options",
+}
+
+// taskFlags are applied to the Task Definition.
+var taskFlags = []string{
+	"task-role-arn",
+	"execution-role-arn",
+	"task-cpu",
+	"task-memory",
+}
+
+// networkFlags build the awsvpc network configuration.
+var networkFlags = []string{
+	"subnets",
+	"security-groups",
+	"assign-public-ip",
+}
+
+func anySet(c *cli.Context, names []string) bool {
+	for _, name := range names {
+		if c.GlobalIsSet(name) {
+			return true
+		}
+	}
+
+	return false
+}
+
+type keyValue struct {
+	Key   string
+	Value string
+}
+
+// keyValues reads the KEY=VALUE pairs of a repeatable flag. Drone and
+// Woodpecker pass map settings as a JSON object, which is accepted too.
+func keyValues(c *cli.Context, name string) ([]keyValue, error) {
+	kvs, err := parseKeyValues(c.GlobalStringSlice(name))
+	if err != nil {
+		return nil, fmt.Errorf("Setting [%s] cannot be parsed: %s", name, err)
+	}
+
+	return kvs, nil
+}
+
+func parseKeyValues(values []string) ([]keyValue, error) {
+	// environment variables are split on commas, which breaks JSON apart
+	joined := strings.TrimSpace(strings.Join(values, ","))
+	if strings.HasPrefix(joined, "{") {
+		m := map[string]interface{}{}
+		if err := json.Unmarshal([]byte(joined), &m); err != nil {
+			return nil, err
+		}
+
+		kvs := []keyValue{}
+		for k, v := range m {
+			if s, ok := v.(string); ok {
+				kvs = append(kvs, keyValue{k, s})
+			} else {
+				kvs = append(kvs, keyValue{k, fmt.Sprint(v)})
+			}
+		}
+		sort.Slice(kvs, func(i, j int) bool { return kvs[i].Key < kvs[j].Key })
+		return kvs, nil
+	}
+
+	kvs := []keyValue{}
+	for _, v := range values {
+		if strings.TrimSpace(v) == "" {
+			continue
+		}
+
+		kv := strings.SplitN(v, "=", 2)
+		if len(kv) != 2 || kv[0] == "" {
+			return nil, fmt.Errorf("[%s] must be KEY=VALUE", v)
+		}
+		kvs = append(kvs, keyValue{strings.TrimSpace(kv[0]), kv[1]})
+	}
+
+	return kvs, nil
+}
+
+// parsePortMapping reads a port in the docker run format,
+// [hostPort:]containerPort[/protocol].
+func parsePortMapping(port string) (*awsecs.PortMapping, error) {
+	pm := &awsecs.PortMapping{}
+
+	port = strings.TrimSpace(port)
+	if i := strings.Index(port, "/"); i >= 0 {
+		pm.Protocol = aws.String(strings.ToLower(port[i+1:]))
+		port = port[:i]
+	}
+
+	ports := strings.Split(port, ":")
+	if len(ports) > 2 {
+		return nil, fmt.Errorf("Port mapping [%s] must be [hostPort:]containerPort[/protocol]", port)
+	}
+	for i, p := range ports {
+		n, err := strconv.ParseInt(p, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("Port mapping [%s] must be [hostPort:]containerPort[/protocol]", port)
+		}
+		if i == len(ports)-1 {
+			pm.ContainerPort = aws.Int64(n)
+		} else {
+			pm.HostPort = aws.Int64(n)
+		}
+	}
+
+	return pm, nil
+}
+
+// setContainerDefinitions reads the container-definitions setting, a JSON
+// array of containers in the task definition spec shape. Containers replace
+// those of the same name in task, others are appended.
+func setContainerDefinitions(c *cli.Context, task *ecs.TaskDefinition) error {
+	cds := []*ecs.ContainerDefinition{}
+	if err := json.Unmarshal([]byte(c.GlobalString("container-definitions")), &cds); err != nil {
+		return fmt.Errorf("Setting [container-definitions] cannot be parsed: %s", err)
+	}
+
+	for _, cd := range cds {
+		replaced := false
+		for i, tcd := range task.ContainerDefinitions {
+			if tcd != nil && cd != nil && tcd.Name == cd.Name {
+				task.ContainerDefinitions[i] = cd
+				replaced = true
+			}
+		}
+		if !replaced {
+			task.ContainerDefinitions = append(task.ContainerDefinitions, cd)
+		}
+	}
+
+	return nil
+}
+
+func setContainer(c *cli.Context, container *ecs.ContainerDefinition) error {
+	if c.GlobalIsSet("docker-image") {
+		s := c.GlobalString("docker-image")
+		container.Image = &s
+	}
+	if c.GlobalIsSet("environment-variables") {
+		kvs, err := keyValues(c, "environment-variables")
+		if err != nil {
+			return err
+		}
+		container.Environment = []*awsecs.KeyValuePair{}
+		for _, kv := range kvs {
+			container.Environment = append(container.Environment, &awsecs.KeyValuePair{
+				Name:  aws.String(kv.Key),
+				Value: aws.String(kv.Value),
+			})
+		}
+	}
+	if c.GlobalIsSet("secrets") {
+		kvs, err := keyValues(c, "secrets")
+		if err != nil {
+			return err
+		}
+		container.Secrets = []*awsecs.Secret{}
+		for _, kv := range kvs {
+			container.Secrets = append(container.Secrets, &awsecs.Secret{
+				Name:      aws.String(kv.Key),
+				ValueFrom: aws.String(kv.Value),
+			})
+		}
+	}
+	if c.GlobalIsSet("merge-environment-variables") {
+		kvs, err := keyValues(c, "merge-environment-variables")
+		if err != nil {
+			return err
+		}
+		container.MergeEnvironment = []*awsecs.KeyValuePair{}
+		for _, kv := range kvs {
+			container.MergeEnvironment = append(container.MergeEnvironment, &awsecs.KeyValuePair{
+				Name:  aws.String(kv.Key),
+				Value: aws.String(kv.Value),
+			})
+		}
+	}
+	if c.GlobalIsSet("unset-environment-variables") {
+		container.UnsetEnvironment = c.GlobalStringSlice("unset-environment-variables")
+	}
+	if c.GlobalIsSet("environment-file") {
+		container.EnvironmentFile = c.GlobalString("environment-file")
+	}
+	if c.GlobalIsSet("merge-secrets") {
+		kvs, err := keyValues(c, "merge-secrets")
+		if err != nil {
+			return err
+		}
+		container.MergeSecrets = []*awsecs.Secret{}
+		for _, kv := range kvs {
+			container.MergeSecrets = append(container.MergeSecrets, &awsecs.Secret{
+				Name:      aws.String(kv.Key),
+				ValueFrom: aws.String(kv.Value),
+			})
+		}
+	}
+	if c.GlobalIsSet("unset-secrets") {
+		container.UnsetSecrets = c.GlobalStringSlice("unset-secrets")
+	}
+	if c.GlobalIsSet("secrets-path") {
+		container.SecretsPath = c.GlobalString("secrets-path")
+	}
+	if c.GlobalIsSet("container-cpu") {
+		i := c.GlobalInt64("container-cpu")
+		container.Cpu = &i
+	}
+	if c.GlobalIsSet("container-memory") {
+		i := c.GlobalInt64("container-memory")
+		container.Memory = &i
+	}
+	if c.GlobalIsSet("container-memory-reservation") {
+		i := c.GlobalInt64("container-memory-reservation")
+		container.MemoryReservation = &i
+	}
+	if c.GlobalIsSet("port-mappings") {
+		container.PortMappings = []*awsecs.PortMapping{}
+		for _, p := range c.GlobalStringSlice("port-mappings") {
+			pm, err := parsePortMapping(p)
+			if err != nil {
+				return err
+			}
+			container.PortMappings = append(container.PortMappings, pm)
+		}
+	}
+	if c.GlobalIsSet("log-options") && !c.GlobalIsSet("log-driver") {
+		return fmt.Errorf("Setting [log-options] needs [log-driver]")
+	}
+	if c.GlobalIsSet("log-driver") {
+		container.LogConfiguration = &awsecs.LogConfiguration{LogDriver: aws.String(c.GlobalString("log-driver"))}
+		kvs, err := keyValues(c, "log-options")
+		if err != nil {
+			return err
+		}
+		if len(kvs) > 0 {
+			container.LogConfiguration.Options = map[string]*string{}
+		}
+		for _, kv := range kvs {
+			container.LogConfiguration.Options[kv.Key] = aws.String(kv.Value)
+		}
+	}
+
+	return nil
+}
+
+func setTask(c *cli.Context, task *ecs.TaskDefinition) {
+	if c.GlobalIsSet("task-role-arn") {
+		s := c.GlobalString("task-role-arn")
+		task.TaskRoleArn = &s
+	}
+	if c.GlobalIsSet("execution-role-arn") {
+		s := c.GlobalString("execution-role-arn")
+		task.ExecutionRoleArn = &s
+	}
+	if c.GlobalIsSet("task-cpu") {
+		s := c.GlobalString("task-cpu")
+		task.Cpu = &s
+	}
+	if c.GlobalIsSet("task-memory") {
+		s := c.GlobalString("task-memory")
+		task.Memory = &s
+	}
+}
+
+// newNetworkConfiguration applies the awsvpc settings on top of nc, which may
+// be nil. It returns nc as is when none of them are set. The settings left
+// out are filled in from the running Service when it is updated or its
+// Task is run.
+func newNetworkConfiguration(c *cli.Context, nc *awsecs.NetworkConfiguration) *awsecs.NetworkConfiguration {
+	if !anySet(c, networkFlags) {
+		return nc
+	}
+
+	if nc == nil {
+		nc = &awsecs.NetworkConfiguration{}
+	}
+	if nc.AwsvpcConfiguration == nil {
+		nc.AwsvpcConfiguration = &awsecs.AwsVpcConfiguration{}
+	}
+
+	vpc := nc.AwsvpcConfiguration
+	if c.GlobalIsSet("subnets") {
+		vpc.Subnets = aws.StringSlice(c.GlobalStringSlice("subnets"))
+	}
+	if c.GlobalIsSet("security-groups") {
+		vpc.SecurityGroups = aws.StringSlice(c.GlobalStringSlice("security-groups"))
+	}
+	if c.GlobalIsSet("assign-public-ip") {
+		if c.GlobalBool("assign-public-ip") {
+			vpc.AssignPublicIp = aws.String(awsecs.AssignPublicIpEnabled)
+		} else {
+			vpc.AssignPublicIp = aws.String(awsecs.AssignPublicIpDisabled)
+		}
+	}
+
+	return nc
+}
+
+// githubActionsInputs maps the INPUT_* variables of a GitHub Action, named
+// after the flags of app, to the first variable each flag reads, so one
+// binary serves Drone, Woodpecker and GitHub Actions. GitHub keeps the dashes
+// of input names, so both INPUT_CONTAINER-NAME and INPUT_CONTAINER_NAME set
+// container-name.
+func githubActionsInputs(app *cli.App) {
+	if os.Getenv("GITHUB_ACTIONS") != "true" {
+		return
+	}
+
+	flags := append([]cli.Flag{}, app.Flags...)
+	for _, cmd := range app.Commands {
+		flags = append(flags, cmd.Flags...)
+	}
+
+	for _, f := range flags {
+		name := strings.ToUpper(strings.TrimSpace(strings.Split(f.GetName(), ",")[0]))
+		envVar := strings.TrimSpace(strings.Split(flagEnvVar(f), ",")[0])
+		if envVar == "" {
+			continue
+		}
+		if _, ok := os.LookupEnv(envVar); ok {
+			continue
+		}
+
+		for _, input := range []string{"INPUT_" + name, "INPUT_" + strings.Replace(name, "-", "_", -1)} {
+			if v := os.Getenv(input); v != "" {
+				os.Setenv(envVar, v)
+				break
+			}
+		}
+	}
+}
+
+// flagEnvVar returns the EnvVar field every flag type of cli has, but which
+// the Flag interface does not expose.
+func flagEnvVar(f cli.Flag) string {
+	v := reflect.Indirect(reflect.ValueOf(f))
+	if v.Kind() != reflect.Struct {
+		return ""
+	}
+	if e := v.FieldByName("EnvVar"); e.IsValid() && e.Kind() == reflect.String {
+		return e.String()
+	}
+
+	return ""
+}
+
+// writeGitHubOutput appends the step outputs to the GITHUB_OUTPUT file, when
+// running as a GitHub Action.
+func writeGitHubOutput(outputs []keyValue) error {
+	path := os.Getenv("GITHUB_OUTPUT")
+	if path == "" {
+		return nil
+	}
+
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	for _, o := range outputs {
+		if o.Value == "" {
+			continue
+		}
+		if _, err := fmt.Fprintf(f, "%s=%s\n", o.Key, o.Value); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// pruneError returns the error of a prune on a single line, as GitHub outputs
+// cannot hold several.
+func pruneError(r *ecs.PruneResult) string {
+	if r == nil {
+		return ""
+	}
+
+	return strings.Join(strings.Fields(r.Error), " ")
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"flag"
+	"os"
+	"reflect"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	awsecs "github.com/aws/aws-sdk-go/service/ecs"
+	"github.com/urfave/cli"
+)
+
+func TestParseKeyValues(t *testing.T) {
+	tests := []struct {
+		name   string
+		values []string
+		want   []keyValue
+		err    bool
+	}{
+		{name: "pairs", values: []string{"A=1", "B=x=y"}, want: []keyValue{{"A", "1"}, {"B", "x=y"}}},
+		{name: "JSON split on commas", values: []string{`{"B":"2"`, `"A":1}`}, want: []keyValue{{"A", "1"}, {"B", "2"}}},
+		{name: "empty", values: []string{}, want: []keyValue{}},
+		{name: "missing value", values: []string{"A"}, err: true},
+		{name: "broken JSON", values: []string{`{"A":`}, err: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseKeyValues(tt.values)
+			if tt.err != (err != nil) {
+				t.Fatalf("parseKeyValues() = %v, want error %v", err, tt.err)
+			}
+			if !tt.err && !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseKeyValues() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParsePortMapping(t *testing.T) {
+	tests := []struct {
+		port string
+		want *awsecs.PortMapping
+		err  bool
+	}{
+		{port: "80", want: &awsecs.PortMapping{ContainerPort: aws.Int64(80)}},
+		{port: "8080:80", want: &awsecs.PortMapping{HostPort: aws.Int64(8080), ContainerPort: aws.Int64(80)}},
+		{port: "53/UDP", want: &awsecs.PortMapping{ContainerPort: aws.Int64(53), Protocol: aws.String("udp")}},
+		{port: "1:2:3", err: true},
+		{port: "http", err: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.port, func(t *testing.T) {
+			got, err := parsePortMapping(tt.port)
+			if tt.err != (err != nil) {
+				t.Fatalf("parsePortMapping() = %v, want error %v", err, tt.err)
+			}
+			if !tt.err && !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parsePortMapping() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNewNetworkConfiguration(t *testing.T) {
+	set := flag.NewFlagSet("test", flag.ContinueOnError)
+	for _, f := range []cli.Flag{
+		cli.StringSliceFlag{Name: "subnets"},
+		cli.StringSliceFlag{Name: "security-groups"},
+		cli.BoolFlag{Name: "assign-public-ip"},
+	} {
+		f.Apply(set)
+	}
+	if err := set.Parse([]string{"--security-groups", "sg-2"}); err != nil {
+		t.Fatal(err)
+	}
+	c := cli.NewContext(cli.NewApp(), set, nil)
+
+	// the subnets are left for the Service or Task to fill in from the
+	// running Service, rather than sent as an empty list
+	want := &awsecs.NetworkConfiguration{AwsvpcConfiguration: &awsecs.AwsVpcConfiguration{
+		SecurityGroups: aws.StringSlice([]string{"sg-2"}),
+	}}
+	if got := newNetworkConfiguration(c, nil); !reflect.DeepEqual(got, want) {
+		t.Errorf("newNetworkConfiguration() = %v, want %v", got, want)
+	}
+}
+
+func TestGithubActionsInputs(t *testing.T) {
+	app := cli.NewApp()
+	app.Flags = []cli.Flag{
+		cli.StringFlag{Name: "container-name", EnvVar: "PLUGIN_CONTAINER"},
+		cli.StringFlag{Name: "docker-image", EnvVar: "PLUGIN_IMAGE"},
+		cli.Int64Flag{Name: "timeout", EnvVar: "PLUGIN_TIMEOUT"},
+		cli.StringFlag{Name: "cluster", EnvVar: "PLUGIN_CLUSTER"},
+	}
+	app.Commands = []cli.Command{
+		{Name: "run-task", Flags: []cli.Flag{cli.StringFlag{Name: "started-by", EnvVar: "PLUGIN_STARTED_BY"}}},
+	}
+
+	env := map[string]string{
+		"GITHUB_ACTIONS":       "true",
+		"INPUT_CONTAINER-NAME": "web",
+		"INPUT_DOCKER_IMAGE":   "web:2",
+		"INPUT_TIMEOUT":        "600",
+		"INPUT_CLUSTER":        "staging",
+		"PLUGIN_CLUSTER":       "production",
+		"INPUT_STARTED-BY":     "ci",
+		"INPUT_CONTAINER":      "worker",
+	}
+	want := map[string]string{
+		"PLUGIN_CONTAINER":  "web",
+		"PLUGIN_IMAGE":      "web:2",
+		"PLUGIN_TIMEOUT":    "600",
+		"PLUGIN_CLUSTER":    "production",
+		"PLUGIN_STARTED_BY": "ci",
+	}
+	for k, v := range env {
+		os.Setenv(k, v)
+	}
+	defer func() {
+		for k := range env {
+			os.Unsetenv(k)
+		}
+		for k := range want {
+			os.Unsetenv(k)
+		}
+	}()
+
+	githubActionsInputs(app)
+
+	for k, v := range want {
+		if got := os.Getenv(k); got != v {
+			t.Errorf("%s = %q, want %q", k, got, v)
+		}
+	}
+}
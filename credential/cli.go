@@ -0,0 +1,36 @@
+package credential
+
+import (
+	"time"
+
+	"github.com/urfave/cli"
+)
+
+// FromContext reads a Credential from the global flags shared by the
+// commands, so it can be used by the default action and the subcommands.
+// region is used when the aws-region flag is not set, and the endpoint flags
+// a command does not define are left empty.
+func FromContext(c *cli.Context, region string) Credential {
+	creds := Credential{}
+	creds.AWSAccessKeyID = c.GlobalString("access-key")
+	creds.AWSSecretAccessKey = c.GlobalString("secret-key")
+	creds.AWSSessionToken = c.GlobalString("session-token")
+	creds.AWSWebIdentityRoleARN = c.GlobalString("web-identity-role-arn")
+	creds.AWSWebIdentityToken = c.GlobalString("web-identity-token")
+	creds.AWSWebIdentityTokenFile = c.GlobalString("web-identity-token-file")
+	creds.AWSAssumeRoleARN = c.GlobalString("assume-role-arn")
+	creds.AWSExternalID = c.GlobalString("assume-role-external-id")
+	creds.AWSRoleSessionName = c.GlobalString("assume-role-session-name")
+	creds.AWSAssumeRoleDuration = time.Duration(c.GlobalInt64("assume-role-duration")) * time.Second
+	creds.AWSRegion = c.GlobalString("aws-region")
+	if creds.AWSRegion == "" {
+		creds.AWSRegion = region
+	}
+	creds.AWSECSEndpoint = c.GlobalString("ecs-endpoint")
+	creds.AWSECREndpoint = c.GlobalString("ecr-endpoint")
+	creds.AWSSTSEndpoint = c.GlobalString("sts-endpoint")
+	creds.AWSLogsEndpoint = c.GlobalString("logs-endpoint")
+	creds.AWSSSMEndpoint = c.GlobalString("ssm-endpoint")
+
+	return creds
+}
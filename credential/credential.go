@@ -1,30 +1,144 @@
 package credential
 
 import (
+	"fmt"
+	"time"
+
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/credentials"
 	"github.com/aws/aws-sdk-go/aws/credentials/stscreds"
+	"github.com/aws/aws-sdk-go/aws/endpoints"
 	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/cloudwatchlogs"
+	"github.com/aws/aws-sdk-go/service/ecr"
+	"github.com/aws/aws-sdk-go/service/ecs"
+	"github.com/aws/aws-sdk-go/service/ssm"
+	"github.com/aws/aws-sdk-go/service/sts"
 )
 
 type Credential struct {
 	AWSAccessKeyID     string
 	AWSSecretAccessKey string
-	AWSAssumeRoleARN   string
-	AWSRegion          string
+	AWSSessionToken    string
+
+	AWSWebIdentityRoleARN   string
+	AWSWebIdentityToken     string
+	AWSWebIdentityTokenFile string
+
+	AWSAssumeRoleARN      string
+	AWSExternalID         string
+	AWSRoleSessionName    string
+	AWSAssumeRoleDuration time.Duration
+
+	AWSRegion string
+
+	// custom endpoints, such as LocalStack or a local stand-in
+	AWSECSEndpoint  string
+	AWSECREndpoint  string
+	AWSSTSEndpoint  string
+	AWSLogsEndpoint string
+	AWSSSMEndpoint  string
 }
 
-func (c *Credential) NewSession() *session.Session {
+// NewSession creates a session from the static keys, or the default
+// credential chain when they are not set. A web identity role replaces those
+// credentials, and an assumed role is chained on top of whichever came before.
+func (c *Credential) NewSession() (*session.Session, error) {
 	awsConfig := aws.Config{}
 
 	if c.AWSAccessKeyID != "" && c.AWSSecretAccessKey != "" {
-		awsConfig.Credentials = credentials.NewStaticCredentials(c.AWSAccessKeyID, c.AWSSecretAccessKey, "")
-	} else if c.AWSAssumeRoleARN != "" {
-		awsConfig.Credentials = stscreds.NewCredentials(session.Must(session.NewSession()), c.AWSAssumeRoleARN)
+		awsConfig.Credentials = credentials.NewStaticCredentials(c.AWSAccessKeyID, c.AWSSecretAccessKey, c.AWSSessionToken)
 	}
 	if c.AWSRegion != "" {
 		awsConfig.Region = aws.String(c.AWSRegion)
 	}
+	awsConfig.EndpointResolver = endpoints.ResolverFunc(c.resolveEndpoint)
+
+	sess, err := session.NewSession(&awsConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	if c.AWSWebIdentityRoleARN != "" {
+		token, err := c.webIdentityToken()
+		if err != nil {
+			return nil, err
+		}
+
+		provider := stscreds.NewWebIdentityRoleProviderWithToken(sts.New(sess), c.AWSWebIdentityRoleARN, c.roleSessionName(), token)
+		provider.Duration = c.AWSAssumeRoleDuration
+		awsConfig.Credentials = credentials.NewCredentials(provider)
+		if sess, err = session.NewSession(&awsConfig); err != nil {
+			return nil, err
+		}
+	}
+
+	if c.AWSAssumeRoleARN != "" {
+		provider := &stscreds.AssumeRoleProvider{
+			Client:          sts.New(sess),
+			RoleARN:         c.AWSAssumeRoleARN,
+			RoleSessionName: c.roleSessionName(),
+			Duration:        stscreds.DefaultDuration,
+		}
+		if c.AWSExternalID != "" {
+			provider.ExternalID = aws.String(c.AWSExternalID)
+		}
+		if c.AWSAssumeRoleDuration != 0 {
+			provider.Duration = c.AWSAssumeRoleDuration
+		}
+		awsConfig.Credentials = credentials.NewCredentials(provider)
+		if sess, err = session.NewSession(&awsConfig); err != nil {
+			return nil, err
+		}
+	}
+
+	return sess, nil
+}
+
+func (c *Credential) resolveEndpoint(service, region string, opts ...func(*endpoints.Options)) (endpoints.ResolvedEndpoint, error) {
+	var url string
+	switch service {
+	case ecs.EndpointsID:
+		url = c.AWSECSEndpoint
+	case ecr.EndpointsID:
+		url = c.AWSECREndpoint
+	case sts.EndpointsID:
+		url = c.AWSSTSEndpoint
+	case cloudwatchlogs.EndpointsID:
+		url = c.AWSLogsEndpoint
+	case ssm.EndpointsID:
+		url = c.AWSSSMEndpoint
+	}
+	if url != "" {
+		return endpoints.ResolvedEndpoint{URL: url, SigningRegion: region}, nil
+	}
+
+	return endpoints.DefaultResolver().EndpointFor(service, region, opts...)
+}
+
+func (c *Credential) roleSessionName() string {
+	if c.AWSRoleSessionName != "" {
+		return c.AWSRoleSessionName
+	}
+
+	return fmt.Sprintf("ecs-deploy-%d", time.Now().UTC().UnixNano())
+}
+
+func (c *Credential) webIdentityToken() (stscreds.TokenFetcher, error) {
+	if c.AWSWebIdentityToken != "" {
+		return webIdentityToken(c.AWSWebIdentityToken), nil
+	}
+	if c.AWSWebIdentityTokenFile != "" {
+		return stscreds.FetchTokenPath(c.AWSWebIdentityTokenFile), nil
+	}
+
+	return nil, fmt.Errorf("Web identity role requires a token or a token file")
+}
+
+// webIdentityToken is a token given directly, usually through an environment
+// variable set by the CI runner.
+type webIdentityToken string
 
-	return session.Must(session.NewSession(&awsConfig))
+func (t webIdentityToken) FetchToken(ctx credentials.Context) ([]byte, error) {
+	return []byte(t), nil
 }
@@ -0,0 +1,168 @@
+package e2e
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	awsecs "github.com/aws/aws-sdk-go/service/ecs"
+
+	cred "github.com/carash/ecs-deploy/credential"
+	"github.com/carash/ecs-deploy/ecr"
+	"github.com/carash/ecs-deploy/ecs"
+)
+
+func newCredential(endpoint string) cred.Credential {
+	return cred.Credential{
+		AWSAccessKeyID:     "AKID",
+		AWSSecretAccessKey: "SECRET",
+		AWSRegion:          region,
+		AWSECSEndpoint:     endpoint,
+		AWSECREndpoint:     endpoint,
+		AWSSTSEndpoint:     endpoint,
+		AWSLogsEndpoint:    endpoint,
+	}
+}
+
+// newWebService registers web:1 with a single app container and a Service
+// running it.
+func newWebService(f *fakeAWS) {
+	f.registerTaskDefinition(&awsecs.RegisterTaskDefinitionInput{
+		Family: aws.String("web"),
+		ContainerDefinitions: []*awsecs.ContainerDefinition{
+			{Name: aws.String("app"), Image: aws.String("app:1"), Memory: aws.Int64(512)},
+		},
+	})
+	f.addService("web", "web", 2)
+}
+
+func TestUpdateService(t *testing.T) {
+	for _, strategy := range []string{ecs.WaitHealthStatus, ecs.WaitDeployment, ecs.WaitSteadyState} {
+		t.Run(strategy, func(t *testing.T) {
+			f, server := newFakeAWS()
+			defer server.Close()
+			newWebService(f)
+
+			plugin := ecs.ServicePlugin{
+				AWSCredential: newCredential(server.URL),
+				Service: ecs.Service{
+					Cluster: aws.String("prod"),
+					Service: "web",
+					TaskDefinition: &ecs.TaskDefinition{
+						ContainerDefinitions: []*ecs.ContainerDefinition{{Name: "app", Image: aws.String("app:2")}},
+					},
+				},
+				WaitStrategy: strategy,
+			}
+			if err := plugin.UpdateService(30); err != nil {
+				t.Fatalf("UpdateService() error = %v", err)
+			}
+
+			if got := *f.services["web"].TaskDefinition; !strings.HasSuffix(got, "task-definition/web:2") {
+				t.Errorf("Service task definition = %s, want web:2", got)
+			}
+			td := f.findTaskDefinition("web:2")
+			if got := *td.ContainerDefinitions[0].Image; got != "app:2" {
+				t.Errorf("Container image = %s, want app:2", got)
+			}
+			if got := *td.ContainerDefinitions[0].Memory; got != 512 {
+				t.Errorf("Container memory = %d, want inherited 512", got)
+			}
+		})
+	}
+}
+
+func TestUpdateServiceRollback(t *testing.T) {
+	f, server := newFakeAWS()
+	defer server.Close()
+	newWebService(f)
+	f.crashing["app:bad"] = true
+
+	plugin := ecs.ServicePlugin{
+		AWSCredential: newCredential(server.URL),
+		Service: ecs.Service{
+			Cluster: aws.String("prod"),
+			Service: "web",
+			TaskDefinition: &ecs.TaskDefinition{
+				ContainerDefinitions: []*ecs.ContainerDefinition{{Name: "app", Image: aws.String("app:bad")}},
+			},
+		},
+		RollbackOnFailure: true,
+		MaxFailedTasks:    1,
+	}
+	err := plugin.UpdateService(30)
+	if _, ok := err.(*ecs.RollbackError); !ok {
+		t.Fatalf("UpdateService() error = %v, want a RollbackError", err)
+	}
+
+	if got := *f.services["web"].TaskDefinition; !strings.HasSuffix(got, "task-definition/web:1") {
+		t.Errorf("Service task definition = %s, want web:1", got)
+	}
+}
+
+func TestWaitForImage(t *testing.T) {
+	f, server := newFakeAWS()
+	defer server.Close()
+	f.images["api"] = []string{"v1"}
+
+	tests := []struct {
+		name    string
+		tag     string
+		wantErr bool
+	}{
+		{"existing tag", "v1", false},
+		{"missing tag", "v2", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			plugin := ecr.ImagePlugin{
+				AWSCredential: newCredential(server.URL),
+				Image: ecr.Image{
+					RepositoryName: "api",
+					ImageTags:      &[]*string{aws.String(tt.tag)},
+				},
+			}
+			if err := plugin.WaitForImage(1, 2); (err != nil) != tt.wantErr {
+				t.Errorf("WaitForImage() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestWebIdentityRoleChaining(t *testing.T) {
+	f, server := newFakeAWS()
+	defer server.Close()
+
+	creds := cred.Credential{
+		AWSWebIdentityRoleARN: "arn:aws:iam::123456789012:role/ci",
+		AWSWebIdentityToken:   "oidc-token",
+		AWSAssumeRoleARN:      "arn:aws:iam::123456789012:role/deploy",
+		AWSExternalID:         "external",
+		AWSRegion:             region,
+		AWSSTSEndpoint:        server.URL,
+	}
+	sess, err := creds.NewSession()
+	if err != nil {
+		t.Fatalf("NewSession() error = %v", err)
+	}
+	value, err := sess.Config.Credentials.Get()
+	if err != nil {
+		t.Fatalf("Credentials.Get() error = %v", err)
+	}
+
+	if value.AccessKeyID != "AssumeRole-2" {
+		t.Errorf("AccessKeyID = %s, want the chained role", value.AccessKeyID)
+	}
+	if len(f.stsCalls) != 2 {
+		t.Fatalf("STS was called %d times, want 2", len(f.stsCalls))
+	}
+	if got := f.stsCalls[0]["WebIdentityToken"]; got != "oidc-token" {
+		t.Errorf("WebIdentityToken = %s, want oidc-token", got)
+	}
+	if got := f.stsCalls[1]["ExternalId"]; got != "external" {
+		t.Errorf("ExternalId = %s, want external", got)
+	}
+	if got := f.stsCalls[1]["Authorization"]; !strings.Contains(got, "AssumeRoleWithWebIdentity-1") {
+		t.Errorf("AssumeRole was not signed with the web identity credentials: %s", got)
+	}
+}
@@ -0,0 +1,372 @@
+package e2e
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/private/protocol/json/jsonutil"
+	"github.com/aws/aws-sdk-go/service/ecr"
+	"github.com/aws/aws-sdk-go/service/ecs"
+)
+
+const (
+	account = "123456789012"
+	region  = "us-east-1"
+)
+
+// fakeAWS is a local stand-in for the ECS, ECR and STS APIs. New deployments
+// become healthy right away, unless one of their images is crashing.
+type fakeAWS struct {
+	mu sync.Mutex
+
+	taskDefinitions map[string][]*ecs.TaskDefinition
+	services        map[string]*ecs.Service
+	tasks           []*ecs.Task
+	crashing        map[string]bool
+
+	images map[string][]string
+
+	stsCalls []map[string]string
+}
+
+func newFakeAWS() (*fakeAWS, *httptest.Server) {
+	f := &fakeAWS{
+		taskDefinitions: map[string][]*ecs.TaskDefinition{},
+		services:        map[string]*ecs.Service{},
+		crashing:        map[string]bool{},
+		images:          map[string][]string{},
+	}
+
+	return f, httptest.NewServer(f)
+}
+
+func (f *fakeAWS) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	target := r.Header.Get("X-Amz-Target")
+	switch true {
+	case strings.HasPrefix(target, "AmazonEC2ContainerServiceV20141113."):
+		f.serveECS(w, r, strings.Split(target, ".")[1])
+	case strings.HasPrefix(target, "AmazonEC2ContainerRegistry_V20150921."):
+		f.serveECR(w, r, strings.Split(target, ".")[1])
+	default:
+		f.serveSTS(w, r)
+	}
+}
+
+func (f *fakeAWS) serveECS(w http.ResponseWriter, r *http.Request, op string) {
+	switch op {
+	case "DescribeServices":
+		in := &ecs.DescribeServicesInput{}
+		respond(w, r.Body, in, func() (interface{}, error) { return f.describeServices(in), nil })
+	case "UpdateService":
+		in := &ecs.UpdateServiceInput{}
+		respond(w, r.Body, in, func() (interface{}, error) { return f.updateService(in) })
+	case "DescribeTaskDefinition":
+		in := &ecs.DescribeTaskDefinitionInput{}
+		respond(w, r.Body, in, func() (interface{}, error) { return f.describeTaskDefinition(in) })
+	case "RegisterTaskDefinition":
+		in := &ecs.RegisterTaskDefinitionInput{}
+		respond(w, r.Body, in, func() (interface{}, error) { return f.registerTaskDefinition(in), nil })
+	case "ListTasks":
+		in := &ecs.ListTasksInput{}
+		respond(w, r.Body, in, func() (interface{}, error) { return f.listTasks(in), nil })
+	case "DescribeTasks":
+		in := &ecs.DescribeTasksInput{}
+		respond(w, r.Body, in, func() (interface{}, error) { return f.describeTasks(in), nil })
+	default:
+		fail(w, "UnknownOperationException", op)
+	}
+}
+
+func (f *fakeAWS) serveECR(w http.ResponseWriter, r *http.Request, op string) {
+	switch op {
+	case "DescribeImages":
+		in := &ecr.DescribeImagesInput{}
+		respond(w, r.Body, in, func() (interface{}, error) { return f.describeImages(in) })
+	default:
+		fail(w, "UnknownOperationException", op)
+	}
+}
+
+// serveSTS answers the query protocol with credentials named after the call,
+// so tests can tell which role a session ended up with.
+func (f *fakeAWS) serveSTS(w http.ResponseWriter, r *http.Request) {
+	r.ParseForm()
+	call := map[string]string{"Authorization": r.Header.Get("Authorization")}
+	for k := range r.PostForm {
+		call[k] = r.PostForm.Get(k)
+	}
+	f.stsCalls = append(f.stsCalls, call)
+
+	action := call["Action"]
+	w.Header().Set("Content-Type", "text/xml")
+	fmt.Fprintf(w, `<%[1]sResponse xmlns="https://sts.amazonaws.com/doc/2011-06-15/">
+  <%[1]sResult>
+    <Credentials>
+      <AccessKeyId>%[1]s-%[2]d</AccessKeyId>
+      <SecretAccessKey>secret</SecretAccessKey>
+      <SessionToken>token</SessionToken>
+      <Expiration>%[3]s</Expiration>
+    </Credentials>
+  </%[1]sResult>
+</%[1]sResponse>`, action, len(f.stsCalls), time.Now().Add(time.Hour).UTC().Format(time.RFC3339))
+}
+
+func respond(w http.ResponseWriter, body io.Reader, in interface{}, fn func() (interface{}, error)) {
+	if err := jsonutil.UnmarshalJSON(in, body); err != nil {
+		fail(w, "SerializationException", err.Error())
+		return
+	}
+
+	out, err := fn()
+	if err != nil {
+		fail(w, strings.Split(err.Error(), ":")[0], err.Error())
+		return
+	}
+
+	b, err := jsonutil.BuildJSON(out)
+	if err != nil {
+		fail(w, "SerializationException", err.Error())
+		return
+	}
+	w.Header().Set("Content-Type", "application/x-amz-json-1.1")
+	w.Write(b)
+}
+
+func fail(w http.ResponseWriter, code, message string) {
+	w.Header().Set("Content-Type", "application/x-amz-json-1.1")
+	w.WriteHeader(http.StatusBadRequest)
+	json.NewEncoder(w).Encode(map[string]string{"__type": code, "message": message})
+}
+
+// now is rounded up to the millisecond precision of the wire format, so
+// fake timestamps never sort before the client's own clock.
+func now() *time.Time {
+	t := time.Now().Truncate(time.Millisecond).Add(time.Millisecond)
+	return &t
+}
+
+func (f *fakeAWS) addService(name, taskDefinition string, desired int64) {
+	f.services[name] = &ecs.Service{
+		ServiceArn:   aws.String(fmt.Sprintf("arn:aws:ecs:%s:%s:service/%s", region, account, name)),
+		ServiceName:  aws.String(name),
+		Status:       aws.String("ACTIVE"),
+		DesiredCount: aws.Int64(desired),
+	}
+	f.rollout(f.services[name], f.findTaskDefinition(taskDefinition))
+}
+
+func (f *fakeAWS) describeServices(in *ecs.DescribeServicesInput) *ecs.DescribeServicesOutput {
+	out := &ecs.DescribeServicesOutput{}
+	for _, name := range in.Services {
+		if srv, ok := f.services[*name]; ok {
+			out.Services = append(out.Services, srv)
+		} else {
+			out.Failures = append(out.Failures, &ecs.Failure{Arn: name, Reason: aws.String("MISSING")})
+		}
+	}
+
+	return out
+}
+
+func (f *fakeAWS) updateService(in *ecs.UpdateServiceInput) (*ecs.UpdateServiceOutput, error) {
+	srv, ok := f.services[*in.Service]
+	if !ok {
+		return nil, fmt.Errorf("ServiceNotFoundException: %s", *in.Service)
+	}
+
+	if in.DesiredCount != nil {
+		srv.DesiredCount = in.DesiredCount
+	}
+	td := f.findTaskDefinition(aws.StringValue(srv.TaskDefinition))
+	if in.TaskDefinition != nil {
+		if td = f.findTaskDefinition(*in.TaskDefinition); td == nil {
+			return nil, fmt.Errorf("ClientException: TaskDefinition not found")
+		}
+	}
+	f.rollout(srv, td)
+
+	return &ecs.UpdateServiceOutput{Service: srv}, nil
+}
+
+// rollout replaces the tasks of a Service with tasks of td.
+func (f *fakeAWS) rollout(srv *ecs.Service, td *ecs.TaskDefinition) {
+	group := "service:" + *srv.ServiceName
+	for _, t := range f.tasks {
+		if *t.Group == group && *t.DesiredStatus == ecs.DesiredStatusRunning {
+			t.DesiredStatus = aws.String(ecs.DesiredStatusStopped)
+			t.LastStatus = aws.String(ecs.DesiredStatusStopped)
+			t.StoppedReason = aws.String("Scaling activity initiated by deployment")
+		}
+	}
+
+	crashing := false
+	for _, cd := range td.ContainerDefinitions {
+		crashing = crashing || f.crashing[aws.StringValue(cd.Image)]
+	}
+
+	running := int64(0)
+	for i := int64(0); i < *srv.DesiredCount; i++ {
+		task := &ecs.Task{
+			TaskArn:           aws.String(fmt.Sprintf("arn:aws:ecs:%s:%s:task/%d", region, account, len(f.tasks)+1)),
+			TaskDefinitionArn: td.TaskDefinitionArn,
+			Group:             aws.String(group),
+			CreatedAt:         now(),
+			DesiredStatus:     aws.String(ecs.DesiredStatusRunning),
+			LastStatus:        aws.String(ecs.DesiredStatusRunning),
+			HealthStatus:      aws.String(ecs.HealthStatusHealthy),
+		}
+		if crashing {
+			task.DesiredStatus = aws.String(ecs.DesiredStatusStopped)
+			task.LastStatus = aws.String(ecs.DesiredStatusStopped)
+			task.HealthStatus = aws.String(ecs.HealthStatusUnknown)
+			task.StoppedReason = aws.String("Essential container in task exited")
+			for _, cd := range td.ContainerDefinitions {
+				task.Containers = append(task.Containers, &ecs.Container{Name: cd.Name, ExitCode: aws.Int64(1)})
+			}
+		} else {
+			running++
+		}
+		f.tasks = append(f.tasks, task)
+	}
+
+	rolloutState := ecs.DeploymentRolloutStateCompleted
+	if crashing {
+		rolloutState = ecs.DeploymentRolloutStateInProgress
+	}
+	srv.TaskDefinition = td.TaskDefinitionArn
+	srv.RunningCount = aws.Int64(running)
+	srv.Deployments = []*ecs.Deployment{{
+		Id:             aws.String(fmt.Sprintf("ecs-svc/%d", len(f.tasks))),
+		Status:         aws.String("PRIMARY"),
+		TaskDefinition: td.TaskDefinitionArn,
+		DesiredCount:   srv.DesiredCount,
+		RunningCount:   aws.Int64(running),
+		PendingCount:   aws.Int64(0),
+		RolloutState:   aws.String(rolloutState),
+	}}
+	srv.Events = append([]*ecs.ServiceEvent{{
+		CreatedAt: now(),
+		Message:   aws.String(fmt.Sprintf("(service %s) has started %d tasks", *srv.ServiceName, *srv.DesiredCount)),
+	}}, srv.Events...)
+}
+
+func (f *fakeAWS) findTaskDefinition(name string) *ecs.TaskDefinition {
+	if i := strings.Index(name, "/"); i >= 0 {
+		name = name[i+1:]
+	}
+
+	parts := strings.Split(name, ":")
+	revisions := f.taskDefinitions[parts[0]]
+	if len(revisions) == 0 {
+		return nil
+	}
+	if len(parts) == 1 {
+		return revisions[len(revisions)-1]
+	}
+
+	revision, _ := strconv.Atoi(parts[1])
+	if revision < 1 || revision > len(revisions) {
+		return nil
+	}
+	return revisions[revision-1]
+}
+
+func (f *fakeAWS) describeTaskDefinition(in *ecs.DescribeTaskDefinitionInput) (*ecs.DescribeTaskDefinitionOutput, error) {
+	td := f.findTaskDefinition(*in.TaskDefinition)
+	if td == nil {
+		return nil, fmt.Errorf("ClientException: Unable to describe task definition")
+	}
+
+	return &ecs.DescribeTaskDefinitionOutput{TaskDefinition: td}, nil
+}
+
+func (f *fakeAWS) registerTaskDefinition(in *ecs.RegisterTaskDefinitionInput) *ecs.RegisterTaskDefinitionOutput {
+	revision := int64(len(f.taskDefinitions[*in.Family]) + 1)
+	td := &ecs.TaskDefinition{
+		TaskDefinitionArn:    aws.String(fmt.Sprintf("arn:aws:ecs:%s:%s:task-definition/%s:%d", region, account, *in.Family, revision)),
+		Family:               in.Family,
+		Revision:             aws.Int64(revision),
+		Status:               aws.String("ACTIVE"),
+		ContainerDefinitions: in.ContainerDefinitions,
+		TaskRoleArn:          in.TaskRoleArn,
+		ExecutionRoleArn:     in.ExecutionRoleArn,
+		NetworkMode:          in.NetworkMode,
+		Volumes:              in.Volumes,
+		Cpu:                  in.Cpu,
+		Memory:               in.Memory,
+	}
+	f.taskDefinitions[*in.Family] = append(f.taskDefinitions[*in.Family], td)
+
+	return &ecs.RegisterTaskDefinitionOutput{TaskDefinition: td, Tags: in.Tags}
+}
+
+func (f *fakeAWS) listTasks(in *ecs.ListTasksInput) *ecs.ListTasksOutput {
+	desired := ecs.DesiredStatusRunning
+	if in.DesiredStatus != nil {
+		desired = *in.DesiredStatus
+	}
+
+	out := &ecs.ListTasksOutput{TaskArns: []*string{}}
+	for _, t := range f.tasks {
+		if in.ServiceName != nil && *t.Group != "service:"+*in.ServiceName {
+			continue
+		}
+		if *t.DesiredStatus == desired {
+			out.TaskArns = append(out.TaskArns, t.TaskArn)
+		}
+	}
+
+	return out
+}
+
+func (f *fakeAWS) describeTasks(in *ecs.DescribeTasksInput) *ecs.DescribeTasksOutput {
+	out := &ecs.DescribeTasksOutput{}
+	for _, arn := range in.Tasks {
+		for _, t := range f.tasks {
+			if *t.TaskArn == *arn {
+				out.Tasks = append(out.Tasks, t)
+			}
+		}
+	}
+
+	return out
+}
+
+func (f *fakeAWS) describeImages(in *ecr.DescribeImagesInput) (*ecr.DescribeImagesOutput, error) {
+	tags, ok := f.images[*in.RepositoryName]
+	if !ok {
+		return nil, fmt.Errorf("RepositoryNotFoundException: %s", *in.RepositoryName)
+	}
+
+	out := &ecr.DescribeImagesOutput{}
+	for _, id := range in.ImageIds {
+		found := false
+		for _, tag := range tags {
+			if aws.StringValue(id.ImageTag) == tag {
+				found = true
+				out.ImageDetails = append(out.ImageDetails, &ecr.ImageDetail{
+					RegistryId:     aws.String(account),
+					RepositoryName: in.RepositoryName,
+					ImageTags:      []*string{aws.String(tag)},
+					ImageDigest:    aws.String("sha256:" + strings.Repeat("0", 64)),
+				})
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("ImageNotFoundException: %s", aws.StringValue(id.ImageTag))
+		}
+	}
+
+	return out, nil
+}
@@ -1,88 +1,172 @@
 package ecr
 
 import (
+	"context"
 	"fmt"
 	"time"
 
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/awserr"
-	"github.com/aws/aws-sdk-go/aws/credentials"
-	"github.com/aws/aws-sdk-go/aws/credentials/stscreds"
-	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/ecr"
+	"github.com/aws/aws-sdk-go/service/ecr/ecriface"
+
+	cred "github.com/carash/ecs-deploy/credential"
+	"github.com/carash/ecs-deploy/logger"
 )
 
-type Credential struct {
-	AWSAccessKeyID     *string
-	AWSSecretAccessKey *string
-	AWSAssumeRoleARN   *string
-	AWSRegion          *string
+type ImagePlugin struct {
+	AWSCredential cred.Credential
+	// ECR is used instead of a client created from AWSCredential when set
+	ECR ecriface.ECRAPI
+	// Logger receives the progress messages, which go to stdout by default
+	Logger logger.Logger
+
+	Image Image
+	// Images are waited for together by WaitForImage instead of Image
+	Images []Image
+	// Scan is the policy ScanImage checks the findings against
+	Scan *ScanPolicy
+	// Target is the image Promote writes, with a single tag
+	Target *Image
+
+	// Result is filled in by FindImage, WaitForImage, ScanImage and Promote
+	Result *ImageResult
+	// ImagesResult is filled in by WaitForImage when Images is set
+	ImagesResult *ImagesResult
 }
 
-type ImagePlugin struct {
-	AWSCredential Credential
-	Image         Image
+func (p *ImagePlugin) log() logger.Logger {
+	return logger.OrDefault(p.Logger)
 }
 
-func (c *Credential) newSession() *session.Session {
-	awsConfig := aws.Config{}
+func (p *ImagePlugin) newECR() (ecriface.ECRAPI, error) {
+	if p.ECR != nil {
+		return p.ECR, nil
+	}
 
-	if c.AWSAccessKeyID != nil && c.AWSSecretAccessKey != nil {
-		awsConfig.Credentials = credentials.NewStaticCredentials(*c.AWSAccessKeyID, *c.AWSSecretAccessKey, "")
-	} else if c.AWSAssumeRoleARN != nil {
-		awsConfig.Credentials = stscreds.NewCredentials(session.Must(session.NewSession()), *c.AWSAssumeRoleARN)
+	sess, err := p.AWSCredential.NewSession()
+	if err != nil {
+		return nil, err
 	}
-	if c.AWSRegion != nil {
-		awsConfig.Region = aws.String(*c.AWSRegion)
+
+	return ecr.New(sess), nil
+}
+
+// newRegionalECR returns a client for the images of region, which is the
+// client of newECR when region is empty or the region of AWSCredential.
+func (p *ImagePlugin) newRegionalECR(region string) (ecriface.ECRAPI, error) {
+	if p.ECR != nil {
+		return p.ECR, nil
 	}
 
-	return session.Must(session.NewSession(&awsConfig))
+	return NewRegionalClient(p.AWSCredential, region)
+}
+
+// NewRegionalClient creates an ECR client for the images of region, in the
+// region of c when region is empty. The custom ECR endpoint of c only applies
+// to its own region.
+func NewRegionalClient(c cred.Credential, region string) (ecriface.ECRAPI, error) {
+	if region != "" && region != c.AWSRegion {
+		c.AWSRegion = region
+		c.AWSECREndpoint = ""
+	}
+
+	sess, err := c.NewSession()
+	if err != nil {
+		return nil, err
+	}
+
+	return ecr.New(sess), nil
 }
 
 func (p *ImagePlugin) FindImage() error {
-	reg := ecr.New(p.AWSCredential.newSession())
-	_, err := p.Image.Find(reg)
+	p.Result = &ImageResult{StartedAt: time.Now()}
+
+	reg, err := p.newECR()
+	if err != nil {
+		p.Result.finish(aws.BackgroundContext(), &p.Image, nil, err)
+		return err
+	}
+
+	imgs, err := p.Image.Find(reg)
+	var img *ecr.ImageDetail
+	if len(imgs) > 0 {
+		img = imgs[0]
+	}
+	p.Result.finish(aws.BackgroundContext(), &p.Image, img, err)
 	return err
 }
 
+// WaitForImage polls ECR until Image is found, or until timeout seconds have
+// passed. When Images is set, they are polled concurrently instead, with the
+// same deadline, and ImagesResult is filled in.
 func (p *ImagePlugin) WaitForImage(interval, timeout int64) error {
-	reg := ecr.New(p.AWSCredential.newSession())
+	return p.WaitForImageWithContext(aws.BackgroundContext(), interval, timeout)
+}
+
+// WaitForImageWithContext is the same as WaitForImage, but stops waiting once
+// ctx is cancelled.
+func (p *ImagePlugin) WaitForImageWithContext(ctx aws.Context, interval, timeout int64) error {
+	if len(p.Images) > 0 {
+		return p.waitForImages(ctx, interval, timeout)
+	}
+
+	p.Result = &ImageResult{StartedAt: time.Now()}
+
+	img, err := p.waitForImage(ctx, interval, timeout)
+	p.Result.finish(ctx, &p.Image, img, err)
+	return err
+}
+
+func (p *ImagePlugin) waitForImage(ctx aws.Context, interval, timeout int64) (*ecr.ImageDetail, error) {
+	reg, err := p.newECR()
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, time.Duration(timeout)*time.Second)
+	defer cancel()
 
+	return p.waitFor(ctx, reg, &p.Image, interval)
+}
+
+// pollInterval defaults the interval, in seconds, to 10 seconds when it is
+// not positive.
+func pollInterval(interval int64) time.Duration {
+	if interval > 0 {
+		return time.Duration(interval) * time.Second
+	}
+
+	return 10 * time.Second
+}
+
+// waitFor polls for image until it is found or ctx is done.
+func (p *ImagePlugin) waitFor(ctx aws.Context, reg ecriface.ECRAPI, image *Image, interval int64) (*ecr.ImageDetail, error) {
 	start := time.Now()
-	delay := time.Duration(interval) * time.Second
-	check := make(chan error)
-
-	go func() {
-		for {
-			go func() {
-				imgs, err := p.Image.Find(reg)
-				if err != nil {
-					if aerr, ok := err.(awserr.Error); ok && aerr.Code() == ecr.ErrCodeImageNotFoundException {
-						return
-					}
-					check <- err
-					return
-				}
-				if len(imgs) > 0 {
-					fmt.Printf("Image [%s] found after %d seconds\n", p.Image.DockerTag(), int64(time.Now().Sub(start).Seconds()))
-					check <- nil
-					return
-				}
-			}()
-
-			time.Sleep(delay)
-			fmt.Printf("Waiting for Image [%s], %ds...\n", p.Image.DockerTag(), int64(time.Now().Sub(start).Seconds()))
+	ticker := time.NewTicker(pollInterval(interval))
+	defer ticker.Stop()
+
+	for {
+		imgs, err := image.FindWithContext(ctx, reg)
+		if err != nil && ctx.Err() == nil {
+			if aerr, ok := err.(awserr.Error); !ok || aerr.Code() != ecr.ErrCodeImageNotFoundException {
+				return nil, err
+			}
+		}
+		if len(imgs) > 0 {
+			p.log().Printf("Image [%s] found after %d seconds\n", image.DockerTag(), int64(time.Now().Sub(start).Seconds()))
+			return imgs[0], nil
 		}
-	}()
 
-	select {
-	case err := <-check:
-		if err != nil {
-			return err
+		select {
+		case <-ctx.Done():
+			elapsed := int64(time.Now().Sub(start).Seconds())
+			if ctx.Err() == context.DeadlineExceeded {
+				return nil, fmt.Errorf("Timed out after %ds while waiting for Image [%s]", elapsed, image.DockerTag())
+			}
+			return nil, fmt.Errorf("Cancelled after %ds while waiting for Image [%s]", elapsed, image.DockerTag())
+		case <-ticker.C:
+			p.log().Printf("Waiting for Image [%s], %ds...\n", image.DockerTag(), int64(time.Now().Sub(start).Seconds()))
 		}
-	case <-time.After(time.Duration(timeout) * time.Second):
-		return fmt.Errorf("Timed out after %ds while waiting for Image [%s]\n", int64(time.Now().Sub(start).Seconds()), p.Image.DockerTag())
 	}
-
-	return nil
 }
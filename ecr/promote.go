@@ -0,0 +1,364 @@
+package ecr
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/ecr"
+	"github.com/aws/aws-sdk-go/service/ecr/ecriface"
+)
+
+// layerPartSize is the size of the parts a layer is uploaded in. ECR rejects
+// parts smaller than 5 MiB, except for the last one.
+const layerPartSize = 10 * 1024 * 1024
+
+// manifestMediaTypes are the manifest formats BatchGetImage may return, so
+// that the manifest is not converted and keeps its digest.
+var manifestMediaTypes = []string{
+	"application/vnd.docker.distribution.manifest.v2+json",
+	"application/vnd.docker.distribution.manifest.list.v2+json",
+	"application/vnd.oci.image.manifest.v1+json",
+	"application/vnd.oci.image.index.v1+json",
+}
+
+// manifest holds the fields of image manifests and manifest lists that
+// reference blobs or other manifests.
+type manifest struct {
+	Config    *descriptor  `json:"config"`
+	Layers    []descriptor `json:"layers"`
+	Manifests []descriptor `json:"manifests"`
+}
+
+type descriptor struct {
+	MediaType string `json:"mediaType"`
+	Digest    string `json:"digest"`
+}
+
+// Promote writes the manifest of Image under the tag of Target, keeping its
+// digest. When Target is in another repository or registry, the layers it is
+// missing are copied first. Both must be in the region of the ECR client.
+func (p *ImagePlugin) Promote() error {
+	return p.PromoteWithContext(aws.BackgroundContext())
+}
+
+// PromoteWithContext is the same as Promote, but stops once ctx is cancelled.
+func (p *ImagePlugin) PromoteWithContext(ctx aws.Context) error {
+	p.Result = &ImageResult{StartedAt: time.Now()}
+
+	img, err := p.promote(ctx)
+	p.Result.finish(ctx, &p.Image, img, err)
+	if p.Result.Promotion != nil && err != nil {
+		p.Result.Promotion.Status = p.Result.Status
+	}
+	return err
+}
+
+func (p *ImagePlugin) promote(ctx aws.Context) (*ecr.ImageDetail, error) {
+	if p.Target == nil {
+		return nil, fmt.Errorf("Image cannot be promoted without a target")
+	}
+	if err := p.Target.isValid(); err != nil {
+		return nil, err
+	}
+	if p.Target.ImageDigest != nil || p.Target.ImageTags == nil || len(*p.Target.ImageTags) != 1 {
+		return nil, fmt.Errorf("Target Image [%s] must have a single tag and no digest", p.Target.DockerTag())
+	}
+	if p.Image.Region != "" && p.Target.Region != "" && p.Image.Region != p.Target.Region {
+		return nil, fmt.Errorf("Image cannot be promoted from region [%s] to region [%s]", p.Image.Region, p.Target.Region)
+	}
+	tag := (*p.Target.ImageTags)[0]
+
+	reg, err := p.newECR()
+	if err != nil {
+		return nil, err
+	}
+
+	imgs, err := p.Image.FindWithContext(ctx, reg)
+	if err != nil {
+		return nil, err
+	}
+	if len(imgs) != 1 {
+		return nil, fmt.Errorf("Image [%s] must match a single image to be promoted, found %d", p.Image.DockerTag(), len(imgs))
+	}
+	img := imgs[0]
+	digest := aws.StringValue(img.ImageDigest)
+
+	p.Result.Promotion = &PromoteResult{
+		Source:      p.Image.DockerTag(),
+		Target:      p.Target.DockerTag(),
+		ImageDigest: digest,
+	}
+
+	current, err := p.targetDigest(ctx, reg)
+	if err != nil {
+		return img, err
+	}
+	if current == digest {
+		p.log().Printf("Image [%s] already points to %s\n", p.Target.DockerTag(), digest)
+		p.Result.Promotion.Status = StatusUnchanged
+		return img, nil
+	}
+	if current != "" {
+		immutable, err := p.targetImmutable(ctx, reg)
+		if err != nil {
+			return img, err
+		}
+		if immutable {
+			return img, fmt.Errorf("Tag [%s] of immutable repository [%s] already points to %s", *tag, p.Target.RepositoryName, current)
+		}
+	}
+
+	src, err := p.getManifest(ctx, reg, digest)
+	if err != nil {
+		return img, err
+	}
+
+	if p.crossRepository() {
+		if err := p.copyManifest(ctx, reg, src, false); err != nil {
+			return img, err
+		}
+	}
+
+	p.log().Printf("Promoting Image [%s] to [%s]\n", p.Image.DockerTag(), p.Target.DockerTag())
+	out, err := reg.PutImageWithContext(ctx, &ecr.PutImageInput{
+		RegistryId:             p.Target.RegistryId,
+		RepositoryName:         &p.Target.RepositoryName,
+		ImageManifest:          src.ImageManifest,
+		ImageManifestMediaType: src.ImageManifestMediaType,
+		ImageDigest:            &digest,
+		ImageTag:               tag,
+	})
+	if aerr, ok := err.(awserr.Error); ok && aerr.Code() == ecr.ErrCodeImageAlreadyExistsException {
+		p.Result.Promotion.Status = StatusUnchanged
+		return img, nil
+	}
+	if err != nil {
+		return img, err
+	}
+	if got := aws.StringValue(out.Image.ImageId.ImageDigest); got != digest {
+		return img, fmt.Errorf("Image [%s] was written with digest %s instead of %s", p.Target.DockerTag(), got, digest)
+	}
+
+	p.Result.Promotion.Status = StatusPromoted
+	p.log().Printf("Image [%s] now points to %s\n\n", p.Target.DockerTag(), digest)
+	return img, nil
+}
+
+func (p *ImagePlugin) crossRepository() bool {
+	return p.Image.RepositoryName != p.Target.RepositoryName ||
+		aws.StringValue(p.Image.RegistryId) != aws.StringValue(p.Target.RegistryId)
+}
+
+// targetDigest returns the digest the target tag points to, or an empty
+// string when the tag does not exist yet.
+func (p *ImagePlugin) targetDigest(ctx aws.Context, reg ecriface.ECRAPI) (string, error) {
+	imgs, err := p.Target.FindWithContext(ctx, reg)
+	if aerr, ok := err.(awserr.Error); ok && aerr.Code() == ecr.ErrCodeImageNotFoundException {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	if len(imgs) == 0 {
+		return "", nil
+	}
+
+	return aws.StringValue(imgs[0].ImageDigest), nil
+}
+
+func (p *ImagePlugin) targetImmutable(ctx aws.Context, reg ecriface.ECRAPI) (bool, error) {
+	out, err := reg.DescribeRepositoriesWithContext(ctx, &ecr.DescribeRepositoriesInput{
+		RegistryId:      p.Target.RegistryId,
+		RepositoryNames: []*string{&p.Target.RepositoryName},
+	})
+	if err != nil {
+		return false, err
+	}
+	if len(out.Repositories) == 0 {
+		return false, fmt.Errorf("Repository [%s] cannot be found", p.Target.RepositoryName)
+	}
+
+	return aws.StringValue(out.Repositories[0].ImageTagMutability) == ecr.ImageTagMutabilityImmutable, nil
+}
+
+// getManifest reads a manifest of the source repository by digest.
+func (p *ImagePlugin) getManifest(ctx aws.Context, reg ecriface.ECRAPI, digest string) (*ecr.Image, error) {
+	out, err := reg.BatchGetImageWithContext(ctx, &ecr.BatchGetImageInput{
+		RegistryId:         p.Image.RegistryId,
+		RepositoryName:     &p.Image.RepositoryName,
+		ImageIds:           []*ecr.ImageIdentifier{{ImageDigest: &digest}},
+		AcceptedMediaTypes: aws.StringSlice(manifestMediaTypes),
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(out.Failures) > 0 {
+		return nil, fmt.Errorf("Manifest %s of [%s] cannot be read: %s", digest, p.Image.RepositoryName, aws.StringValue(out.Failures[0].FailureReason))
+	}
+	if len(out.Images) == 0 {
+		return nil, fmt.Errorf("Manifest %s of [%s] cannot be found", digest, p.Image.RepositoryName)
+	}
+
+	return out.Images[0], nil
+}
+
+// copyManifest copies the blobs the target repository is missing. The images
+// of a manifest list are copied and put by digest first, so that the list
+// can reference them.
+func (p *ImagePlugin) copyManifest(ctx aws.Context, reg ecriface.ECRAPI, img *ecr.Image, put bool) error {
+	m := manifest{}
+	if err := json.Unmarshal([]byte(aws.StringValue(img.ImageManifest)), &m); err != nil {
+		return fmt.Errorf("Manifest %s cannot be parsed: %s", aws.StringValue(img.ImageId.ImageDigest), err)
+	}
+
+	for _, d := range m.Manifests {
+		child, err := p.getManifest(ctx, reg, d.Digest)
+		if err != nil {
+			return err
+		}
+		if err := p.copyManifest(ctx, reg, child, true); err != nil {
+			return err
+		}
+	}
+
+	blobs := []string{}
+	if m.Config != nil {
+		blobs = append(blobs, m.Config.Digest)
+	}
+	for _, d := range m.Layers {
+		blobs = append(blobs, d.Digest)
+	}
+	if err := p.copyLayers(ctx, reg, blobs); err != nil {
+		return err
+	}
+
+	if !put {
+		return nil
+	}
+	_, err := reg.PutImageWithContext(ctx, &ecr.PutImageInput{
+		RegistryId:             p.Target.RegistryId,
+		RepositoryName:         &p.Target.RepositoryName,
+		ImageManifest:          img.ImageManifest,
+		ImageManifestMediaType: img.ImageManifestMediaType,
+		ImageDigest:            img.ImageId.ImageDigest,
+	})
+	if aerr, ok := err.(awserr.Error); ok && aerr.Code() == ecr.ErrCodeImageAlreadyExistsException {
+		return nil
+	}
+
+	return err
+}
+
+// copyLayers uploads the blobs that are not available in the target
+// repository.
+func (p *ImagePlugin) copyLayers(ctx aws.Context, reg ecriface.ECRAPI, digests []string) error {
+	if len(digests) == 0 {
+		return nil
+	}
+
+	out, err := reg.BatchCheckLayerAvailabilityWithContext(ctx, &ecr.BatchCheckLayerAvailabilityInput{
+		RegistryId:     p.Target.RegistryId,
+		RepositoryName: &p.Target.RepositoryName,
+		LayerDigests:   aws.StringSlice(digests),
+	})
+	if err != nil {
+		return err
+	}
+
+	available := map[string]bool{}
+	for _, l := range out.Layers {
+		if aws.StringValue(l.LayerAvailability) == ecr.LayerAvailabilityAvailable {
+			available[aws.StringValue(l.LayerDigest)] = true
+		}
+	}
+
+	for _, digest := range digests {
+		if available[digest] {
+			continue
+		}
+		p.log().Printf("Copying layer %s to [%s]\n", digest, p.Target.RepositoryName)
+		if err := p.copyLayer(ctx, reg, digest); err != nil {
+			return fmt.Errorf("Layer %s cannot be copied to [%s]: %s", digest, p.Target.RepositoryName, err)
+		}
+		available[digest] = true
+		p.Result.Promotion.CopiedLayers++
+	}
+
+	return nil
+}
+
+func (p *ImagePlugin) copyLayer(ctx aws.Context, reg ecriface.ECRAPI, digest string) error {
+	dl, err := reg.GetDownloadUrlForLayerWithContext(ctx, &ecr.GetDownloadUrlForLayerInput{
+		RegistryId:     p.Image.RegistryId,
+		RepositoryName: &p.Image.RepositoryName,
+		LayerDigest:    &digest,
+	})
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, aws.StringValue(dl.DownloadUrl), nil)
+	if err != nil {
+		return err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("download failed with %s", resp.Status)
+	}
+
+	up, err := reg.InitiateLayerUploadWithContext(ctx, &ecr.InitiateLayerUploadInput{
+		RegistryId:     p.Target.RegistryId,
+		RepositoryName: &p.Target.RepositoryName,
+	})
+	if err != nil {
+		return err
+	}
+
+	buf := make([]byte, layerPartSize)
+	var first int64
+	for {
+		n, err := io.ReadFull(resp.Body, buf)
+		if n > 0 {
+			last := first + int64(n) - 1
+			_, uerr := reg.UploadLayerPartWithContext(ctx, &ecr.UploadLayerPartInput{
+				RegistryId:     p.Target.RegistryId,
+				RepositoryName: &p.Target.RepositoryName,
+				UploadId:       up.UploadId,
+				LayerPartBlob:  buf[:n],
+				PartFirstByte:  aws.Int64(first),
+				PartLastByte:   aws.Int64(last),
+			})
+			if uerr != nil {
+				return uerr
+			}
+			first = last + 1
+		}
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	_, err = reg.CompleteLayerUploadWithContext(ctx, &ecr.CompleteLayerUploadInput{
+		RegistryId:     p.Target.RegistryId,
+		RepositoryName: &p.Target.RepositoryName,
+		UploadId:       up.UploadId,
+		LayerDigests:   []*string{&digest},
+	})
+	if aerr, ok := err.(awserr.Error); ok && aerr.Code() == ecr.ErrCodeLayerAlreadyExistsException {
+		return nil
+	}
+
+	return err
+}
@@ -0,0 +1,311 @@
+package ecr
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/ecr"
+
+	"github.com/carash/ecs-deploy/logger"
+)
+
+const manifestMediaType = "application/vnd.docker.distribution.manifest.v2+json"
+
+// fakePromoteECR adds the manifests, layers and tag mutability of each
+// repository to fakeECR, serving the layers from server.
+type fakePromoteECR struct {
+	*fakeECR
+
+	server    *httptest.Server
+	blobs     map[string]string
+	manifests map[string]string
+	// layers and immutable are keyed like the images of fakeECR, layers
+	// with the digest appended
+	layers    map[string]bool
+	immutable map[string]bool
+	uploads   map[string][]byte
+}
+
+func newFakePromoteECR() *fakePromoteECR {
+	f := &fakePromoteECR{
+		fakeECR:   newFakeECR(),
+		blobs:     map[string]string{},
+		manifests: map[string]string{},
+		layers:    map[string]bool{},
+		immutable: map[string]bool{},
+		uploads:   map[string][]byte{},
+	}
+	f.server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, f.blobs[strings.TrimPrefix(r.URL.Path, "/")])
+	}))
+
+	return f
+}
+
+func digestOf(content string) string {
+	return fmt.Sprintf("sha256:%x", sha256.Sum256([]byte(content)))
+}
+
+// createRepository creates an empty repository, unless it exists.
+func (f *fakePromoteECR) createRepository(registry *string, repository string, immutable bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	key := f.key(registry, repository)
+	if _, ok := f.images[key]; !ok {
+		f.images[key] = []*ecr.ImageDetail{}
+	}
+	f.immutable[key] = immutable
+}
+
+// digest returns the digest tag points to in the repository.
+func (f *fakePromoteECR) digest(registry *string, repository, tag string) (string, bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	for _, img := range f.images[f.key(registry, repository)] {
+		for _, t := range img.ImageTags {
+			if *t == tag {
+				return *img.ImageDigest, true
+			}
+		}
+	}
+
+	return "", false
+}
+
+// tag stores the image of digest in the repository, and moves tag to it.
+func (f *fakePromoteECR) tag(registry *string, repository, digest, tag string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	key := f.key(registry, repository)
+	var found *ecr.ImageDetail
+	for _, img := range f.images[key] {
+		tags := []*string{}
+		for _, t := range img.ImageTags {
+			if *t != tag {
+				tags = append(tags, t)
+			}
+		}
+		img.ImageTags = tags
+		if *img.ImageDigest == digest {
+			found = img
+		}
+	}
+	if found == nil {
+		found = &ecr.ImageDetail{RegistryId: registry, RepositoryName: aws.String(repository), ImageDigest: aws.String(digest)}
+		f.images[key] = append(f.images[key], found)
+	}
+	if tag != "" {
+		found.ImageTags = append(found.ImageTags, aws.String(tag))
+	}
+}
+
+// pushLayers stores an image made of the given layers under tag, and
+// returns its digest.
+func (f *fakePromoteECR) pushLayers(registry *string, repository, tag string, layers ...string) string {
+	f.createRepository(registry, repository, false)
+	key := f.key(registry, repository)
+	descriptors := []string{}
+	for _, content := range layers {
+		d := digestOf(content)
+		f.blobs[d] = content
+		f.layers[key+"@"+d] = true
+		descriptors = append(descriptors, fmt.Sprintf(`{"digest":%q}`, d))
+	}
+
+	m := fmt.Sprintf(`{"schemaVersion":2,"layers":[%s]}`, strings.Join(descriptors, ","))
+	f.manifests[digestOf(m)] = m
+	f.tag(registry, repository, digestOf(m), tag)
+	return digestOf(m)
+}
+
+func (f *fakePromoteECR) DescribeRepositoriesWithContext(ctx aws.Context, in *ecr.DescribeRepositoriesInput, opts ...request.Option) (*ecr.DescribeRepositoriesOutput, error) {
+	key := f.key(in.RegistryId, *in.RepositoryNames[0])
+	if _, ok := f.images[key]; !ok {
+		return nil, awserr.New(ecr.ErrCodeRepositoryNotFoundException, "The repository does not exist.", nil)
+	}
+	mutability := ecr.ImageTagMutabilityMutable
+	if f.immutable[key] {
+		mutability = ecr.ImageTagMutabilityImmutable
+	}
+
+	return &ecr.DescribeRepositoriesOutput{Repositories: []*ecr.Repository{
+		{RepositoryName: in.RepositoryNames[0], ImageTagMutability: aws.String(mutability)},
+	}}, nil
+}
+
+func (f *fakePromoteECR) BatchGetImageWithContext(ctx aws.Context, in *ecr.BatchGetImageInput, opts ...request.Option) (*ecr.BatchGetImageOutput, error) {
+	out := &ecr.BatchGetImageOutput{}
+	for _, id := range in.ImageIds {
+		if _, err := f.DescribeImagesWithContext(ctx, &ecr.DescribeImagesInput{
+			RegistryId:     in.RegistryId,
+			RepositoryName: in.RepositoryName,
+			ImageIds:       []*ecr.ImageIdentifier{{ImageDigest: id.ImageDigest}},
+		}); err != nil {
+			out.Failures = append(out.Failures, &ecr.ImageFailure{ImageId: id, FailureReason: aws.String("Requested image not found")})
+			continue
+		}
+		out.Images = append(out.Images, &ecr.Image{
+			ImageId:                id,
+			ImageManifest:          aws.String(f.manifests[*id.ImageDigest]),
+			ImageManifestMediaType: aws.String(manifestMediaType),
+		})
+	}
+
+	return out, nil
+}
+
+func (f *fakePromoteECR) PutImageWithContext(ctx aws.Context, in *ecr.PutImageInput, opts ...request.Option) (*ecr.PutImageOutput, error) {
+	key := f.key(in.RegistryId, *in.RepositoryName)
+	m := manifest{}
+	if err := json.Unmarshal([]byte(*in.ImageManifest), &m); err != nil {
+		return nil, err
+	}
+	for _, l := range m.Layers {
+		if !f.layers[key+"@"+l.Digest] {
+			return nil, awserr.New(ecr.ErrCodeLayersNotFoundException, "The image manifest references layers that are not in the repository.", nil)
+		}
+	}
+
+	digest := digestOf(*in.ImageManifest)
+	tag := aws.StringValue(in.ImageTag)
+	if current, ok := f.digest(in.RegistryId, *in.RepositoryName, tag); ok {
+		if current == digest {
+			return nil, awserr.New(ecr.ErrCodeImageAlreadyExistsException, "The image already exists.", nil)
+		}
+		if f.immutable[key] {
+			return nil, awserr.New(ecr.ErrCodeImageTagAlreadyExistsException, "The image tag already exists.", nil)
+		}
+	}
+	f.manifests[digest] = *in.ImageManifest
+	f.tag(in.RegistryId, *in.RepositoryName, digest, tag)
+
+	return &ecr.PutImageOutput{Image: &ecr.Image{ImageId: &ecr.ImageIdentifier{ImageDigest: aws.String(digest), ImageTag: in.ImageTag}}}, nil
+}
+
+func (f *fakePromoteECR) BatchCheckLayerAvailabilityWithContext(ctx aws.Context, in *ecr.BatchCheckLayerAvailabilityInput, opts ...request.Option) (*ecr.BatchCheckLayerAvailabilityOutput, error) {
+	key := f.key(in.RegistryId, *in.RepositoryName)
+	out := &ecr.BatchCheckLayerAvailabilityOutput{}
+	for _, d := range in.LayerDigests {
+		availability := ecr.LayerAvailabilityUnavailable
+		if f.layers[key+"@"+*d] {
+			availability = ecr.LayerAvailabilityAvailable
+		}
+		out.Layers = append(out.Layers, &ecr.Layer{LayerDigest: d, LayerAvailability: aws.String(availability)})
+	}
+
+	return out, nil
+}
+
+func (f *fakePromoteECR) GetDownloadUrlForLayerWithContext(ctx aws.Context, in *ecr.GetDownloadUrlForLayerInput, opts ...request.Option) (*ecr.GetDownloadUrlForLayerOutput, error) {
+	if !f.layers[f.key(in.RegistryId, *in.RepositoryName)+"@"+*in.LayerDigest] {
+		return nil, awserr.New(ecr.ErrCodeLayersNotFoundException, "The layer does not exist.", nil)
+	}
+
+	return &ecr.GetDownloadUrlForLayerOutput{DownloadUrl: aws.String(f.server.URL + "/" + *in.LayerDigest), LayerDigest: in.LayerDigest}, nil
+}
+
+func (f *fakePromoteECR) InitiateLayerUploadWithContext(ctx aws.Context, in *ecr.InitiateLayerUploadInput, opts ...request.Option) (*ecr.InitiateLayerUploadOutput, error) {
+	id := fmt.Sprint(len(f.uploads) + 1)
+	f.uploads[id] = []byte{}
+
+	return &ecr.InitiateLayerUploadOutput{UploadId: aws.String(id), PartSize: aws.Int64(layerPartSize)}, nil
+}
+
+func (f *fakePromoteECR) UploadLayerPartWithContext(ctx aws.Context, in *ecr.UploadLayerPartInput, opts ...request.Option) (*ecr.UploadLayerPartOutput, error) {
+	if *in.PartFirstByte != int64(len(f.uploads[*in.UploadId])) {
+		return nil, awserr.New(ecr.ErrCodeInvalidLayerPartException, "The part is out of order.", nil)
+	}
+	f.uploads[*in.UploadId] = append(f.uploads[*in.UploadId], in.LayerPartBlob...)
+
+	return &ecr.UploadLayerPartOutput{UploadId: in.UploadId}, nil
+}
+
+func (f *fakePromoteECR) CompleteLayerUploadWithContext(ctx aws.Context, in *ecr.CompleteLayerUploadInput, opts ...request.Option) (*ecr.CompleteLayerUploadOutput, error) {
+	content := f.uploads[*in.UploadId]
+	if digestOf(string(content)) != *in.LayerDigests[0] {
+		return nil, awserr.New(ecr.ErrCodeInvalidLayerException, "The layer digest does not match.", nil)
+	}
+	f.layers[f.key(in.RegistryId, *in.RepositoryName)+"@"+*in.LayerDigests[0]] = true
+
+	return &ecr.CompleteLayerUploadOutput{LayerDigest: in.LayerDigests[0]}, nil
+}
+
+func TestPromote(t *testing.T) {
+	tests := []struct {
+		name      string
+		target    Image
+		immutable bool
+		existing  bool
+		status    string
+		copied    int
+		err       string
+	}{
+		{name: "same repository", target: Image{RepositoryName: "web"}, status: StatusPromoted},
+		{name: "other repository", target: Image{RepositoryName: "web-prod"}, status: StatusPromoted, copied: 2},
+		{name: "other registry", target: Image{RegistryId: aws.String("210987654321"), RepositoryName: "web"}, status: StatusPromoted, copied: 2},
+		{name: "moves mutable tag", target: Image{RepositoryName: "web-prod"}, existing: true, status: StatusPromoted, copied: 1},
+		{name: "immutable tag elsewhere", target: Image{RepositoryName: "web-prod"}, existing: true, immutable: true, status: StatusFailed, err: "already points to"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := newFakePromoteECR()
+			defer f.server.Close()
+			digest := f.pushLayers(aws.String("123456789012"), "web", "v1", "base layer", "app layer")
+			if tt.existing {
+				f.pushLayers(tt.target.RegistryId, tt.target.RepositoryName, "prod", "base layer")
+			}
+			f.createRepository(tt.target.RegistryId, tt.target.RepositoryName, tt.immutable)
+
+			target := tt.target
+			target.ImageTags = &[]*string{aws.String("prod")}
+			p := &ImagePlugin{
+				ECR:    f,
+				Logger: logger.NewText(ioutil.Discard),
+				Image:  Image{RegistryId: aws.String("123456789012"), RepositoryName: "web", ImageTags: &[]*string{aws.String("v1")}},
+				Target: &target,
+			}
+
+			err := p.Promote()
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("Promote() = %v, want %q", err, tt.err)
+				}
+			} else if err != nil {
+				t.Fatal(err)
+			}
+
+			if p.Result.Promotion.Status != tt.status {
+				t.Errorf("Promotion.Status = %s, want %s", p.Result.Promotion.Status, tt.status)
+			}
+			if p.Result.Promotion.CopiedLayers != tt.copied {
+				t.Errorf("CopiedLayers = %d, want %d", p.Result.Promotion.CopiedLayers, tt.copied)
+			}
+			if tt.err != "" {
+				return
+			}
+			if got, _ := f.digest(target.RegistryId, target.RepositoryName, "prod"); got != digest {
+				t.Errorf("prod points to %s, want %s", got, digest)
+			}
+
+			if err := p.Promote(); err != nil {
+				t.Fatal(err)
+			}
+			if p.Result.Promotion.Status != StatusUnchanged {
+				t.Errorf("second Promotion.Status = %s, want %s", p.Result.Promotion.Status, StatusUnchanged)
+			}
+		})
+	}
+}
@@ -0,0 +1,130 @@
+package ecr
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+)
+
+// Reference is an ECR image URI, such as
+// 123456789012.dkr.ecr.eu-west-1.amazonaws.com/team/api:v1.2.3@sha256:...
+type Reference struct {
+	RegistryId string
+	Region     string
+	// FIPS is set for the dkr.ecr-fips endpoints
+	FIPS       bool
+	Repository string
+	Tag        string
+	Digest     string
+}
+
+var registryRegex, _ = regexp.Compile(`^(\d{12})\.dkr\.(ecr|ecr-fips)\.([a-z]{2}(?:-[a-z]+)+-\d+)\.(amazonaws\.com|amazonaws\.com\.cn|c2s\.ic\.gov|sc2s\.sgov\.gov)$`)
+var repositoryRegex, _ = regexp.Compile(`^[a-z0-9]+(?:[._-][a-z0-9]+)*(?:/[a-z0-9]+(?:[._-][a-z0-9]+)*)*$`)
+var tagRegex, _ = regexp.Compile(`^[\w][\w.-]{0,127}$`)
+var digestRegex, _ = regexp.Compile(`^sha256:[a-f0-9]{64}$`)
+
+// ParseReference parses an ECR image URI. The tag and the digest are both
+// optional.
+func ParseReference(uri string) (Reference, error) {
+	ref := Reference{}
+
+	slash := strings.Index(uri, "/")
+	if slash < 0 {
+		return ref, fmt.Errorf("Image [%s] is not an ECR image", uri)
+	}
+	match := registryRegex.FindStringSubmatch(uri[:slash])
+	if match == nil {
+		return ref, fmt.Errorf("Image [%s] is not an ECR image", uri)
+	}
+	ref.RegistryId = match[1]
+	ref.FIPS = match[2] == "ecr-fips"
+	ref.Region = match[3]
+	if domain(ref.Region) != match[4] {
+		return ref, fmt.Errorf("Image [%s] has a domain that does not match region [%s]", uri, ref.Region)
+	}
+
+	path := uri[slash+1:]
+	if at := strings.Index(path, "@"); at >= 0 {
+		ref.Digest = path[at+1:]
+		path = path[:at]
+		if !digestRegex.MatchString(ref.Digest) {
+			return ref, fmt.Errorf("Image [%s] has a bad digest [%s]", uri, ref.Digest)
+		}
+	}
+	if colon := strings.LastIndex(path, ":"); colon >= 0 {
+		ref.Tag = path[colon+1:]
+		path = path[:colon]
+		if !tagRegex.MatchString(ref.Tag) {
+			return ref, fmt.Errorf("Image [%s] has a bad tag [%s]", uri, ref.Tag)
+		}
+	}
+	ref.Repository = path
+	if !repositoryRegex.MatchString(ref.Repository) {
+		return ref, fmt.Errorf("Image [%s] has a bad repository [%s]", uri, ref.Repository)
+	}
+
+	return ref, nil
+}
+
+// domain returns the domain of the ECR endpoints in the partition of region.
+func domain(region string) string {
+	switch true {
+	case strings.HasPrefix(region, "cn-"):
+		return "amazonaws.com.cn"
+	case strings.HasPrefix(region, "us-isob-"):
+		return "sc2s.sgov.gov"
+	case strings.HasPrefix(region, "us-iso-"):
+		return "c2s.ic.gov"
+	}
+
+	return "amazonaws.com"
+}
+
+// Registry returns the host of the registry.
+func (r Reference) Registry() string {
+	service := "ecr"
+	if r.FIPS {
+		service = "ecr-fips"
+	}
+
+	return fmt.Sprintf("%s.dkr.%s.%s.%s", r.RegistryId, service, r.Region, domain(r.Region))
+}
+
+// String returns the URI the reference was parsed from.
+func (r Reference) String() string {
+	uri := r.Repository
+	if r.RegistryId != "" && r.Region != "" {
+		uri = r.Registry() + "/" + uri
+	}
+	if r.Tag != "" {
+		uri += ":" + r.Tag
+	}
+	if r.Digest != "" {
+		uri += "@" + r.Digest
+	}
+
+	return uri
+}
+
+// Image returns the image the reference points to. A digest takes over the
+// tag when looking the image up, as it does for docker pull.
+func (r Reference) Image() Image {
+	image := Image{
+		RepositoryName: r.Repository,
+		Region:         r.Region,
+		FIPS:           r.FIPS,
+	}
+	if r.RegistryId != "" {
+		image.RegistryId = aws.String(r.RegistryId)
+	}
+	if r.Tag != "" {
+		image.ImageTags = &[]*string{aws.String(r.Tag)}
+	}
+	if r.Digest != "" {
+		image.ImageDigest = aws.String(r.Digest)
+	}
+
+	return image
+}
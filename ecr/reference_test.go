@@ -0,0 +1,94 @@
+package ecr
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+)
+
+func TestParseReference(t *testing.T) {
+	const digest = "sha256:0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef"
+
+	tests := []struct {
+		uri  string
+		want Reference
+		err  string
+	}{
+		{
+			uri:  "123456789012.dkr.ecr.eu-west-1.amazonaws.com/web",
+			want: Reference{RegistryId: "123456789012", Region: "eu-west-1", Repository: "web"},
+		},
+		{
+			uri:  "123456789012.dkr.ecr.us-east-1.amazonaws.com/team/api:v1.2.3",
+			want: Reference{RegistryId: "123456789012", Region: "us-east-1", Repository: "team/api", Tag: "v1.2.3"},
+		},
+		{
+			uri:  "123456789012.dkr.ecr.us-east-1.amazonaws.com/web@" + digest,
+			want: Reference{RegistryId: "123456789012", Region: "us-east-1", Repository: "web", Digest: digest},
+		},
+		{
+			uri:  "123456789012.dkr.ecr.ap-southeast-2.amazonaws.com/a/b/c_d:prod-1@" + digest,
+			want: Reference{RegistryId: "123456789012", Region: "ap-southeast-2", Repository: "a/b/c_d", Tag: "prod-1", Digest: digest},
+		},
+		{
+			uri:  "123456789012.dkr.ecr.cn-north-1.amazonaws.com.cn/web:latest",
+			want: Reference{RegistryId: "123456789012", Region: "cn-north-1", Repository: "web", Tag: "latest"},
+		},
+		{
+			uri:  "123456789012.dkr.ecr.us-gov-west-1.amazonaws.com/web:1.0",
+			want: Reference{RegistryId: "123456789012", Region: "us-gov-west-1", Repository: "web", Tag: "1.0"},
+		},
+		{
+			uri:  "123456789012.dkr.ecr-fips.us-gov-east-1.amazonaws.com/web:1.0",
+			want: Reference{RegistryId: "123456789012", Region: "us-gov-east-1", FIPS: true, Repository: "web", Tag: "1.0"},
+		},
+		{uri: "nginx:1.19", err: "is not an ECR image"},
+		{uri: "docker.io/library/nginx:1.19", err: "is not an ECR image"},
+		{uri: "123456789012.dkr.ecr.cn-north-1.amazonaws.com/web", err: "does not match region"},
+		{uri: "123456789012.dkr.ecr.us-east-1.amazonaws.com/Web", err: "bad repository"},
+		{uri: "123456789012.dkr.ecr.us-east-1.amazonaws.com/web:", err: "bad tag"},
+		{uri: "123456789012.dkr.ecr.us-east-1.amazonaws.com/web@sha256:abc", err: "bad digest"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.uri, func(t *testing.T) {
+			ref, err := ParseReference(tt.uri)
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("ParseReference() = %v, want %q", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if ref != tt.want {
+				t.Errorf("ParseReference() = %+v, want %+v", ref, tt.want)
+			}
+			if uri := ref.String(); uri != tt.uri {
+				t.Errorf("String() = %s, want %s", uri, tt.uri)
+			}
+			image := ref.Image()
+			if tag := image.DockerTag(); tag != tt.uri {
+				t.Errorf("DockerTag() = %s, want %s", tag, tt.uri)
+			}
+		})
+	}
+}
+
+func TestDescribeByDigest(t *testing.T) {
+	f := newFakeECR()
+	f.push("web", "sha256:aaa", "v1")
+	f.push("web", "sha256:bbb", "v2")
+
+	image := Image{RepositoryName: "web", ImageDigest: aws.String("sha256:bbb"), ImageTags: &[]*string{aws.String("v2")}}
+	imgs, err := image.Find(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(imgs) != 1 || *imgs[0].ImageDigest != "sha256:bbb" {
+		t.Errorf("Find() = %v, want sha256:bbb only", imgs)
+	}
+}
@@ -3,7 +3,9 @@ package ecr
 import (
 	"fmt"
 
+	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/service/ecr"
+	"github.com/aws/aws-sdk-go/service/ecr/ecriface"
 )
 
 type Image struct {
@@ -11,19 +13,32 @@ type Image struct {
 	RepositoryName string
 	ImageDigest    *string
 	ImageTags      *[]*string
+
+	// Region and FIPS only describe the registry in DockerTag
+	Region string
+	FIPS   bool
 }
 
-func (i *Image) DockerTag() string {
-	repo := i.RepositoryName
-	if i.RegistryId != nil {
-		repo = fmt.Sprintf("%s.dkr.ecr.region.amazonaws.com/%s", *i.RegistryId, i.RepositoryName)
+// Reference returns the URI of the image. The registry is left out when the
+// registry ID or the region is unknown, and the tag when there is not a
+// single one.
+func (i *Image) Reference() Reference {
+	ref := Reference{
+		RegistryId: aws.StringValue(i.RegistryId),
+		Region:     i.Region,
+		FIPS:       i.FIPS,
+		Repository: i.RepositoryName,
+		Digest:     aws.StringValue(i.ImageDigest),
 	}
-
 	if i.ImageTags != nil && len(*i.ImageTags) == 1 {
-		return fmt.Sprintf("%s:%s", repo, *(*i.ImageTags)[0])
+		ref.Tag = aws.StringValue((*i.ImageTags)[0])
 	}
 
-	return i.RepositoryName
+	return ref
+}
+
+func (i *Image) DockerTag() string {
+	return i.Reference().String()
 }
 
 func (i *Image) isValid() error {
@@ -34,13 +49,17 @@ func (i *Image) isValid() error {
 	return nil
 }
 
-func (i *Image) Find(reg *ecr.ECR) ([]*ecr.ImageDetail, error) {
+func (i *Image) Find(reg ecriface.ECRAPI) ([]*ecr.ImageDetail, error) {
+	return i.FindWithContext(aws.BackgroundContext(), reg)
+}
+
+func (i *Image) FindWithContext(ctx aws.Context, reg ecriface.ECRAPI) ([]*ecr.ImageDetail, error) {
 	if err := i.isValid(); err != nil {
 		return nil, err
 	}
 
 	input := i.unpackDescribeInput()
-	imgout, err := reg.DescribeImages(input)
+	imgout, err := reg.DescribeImagesWithContext(ctx, input)
 	if err != nil {
 		return nil, err
 	}
@@ -55,8 +74,7 @@ func (i *Image) unpackDescribeInput() *ecr.DescribeImagesInput {
 	describeImagesInput.RegistryId = i.RegistryId
 	if i.ImageDigest != nil {
 		describeImagesInput.ImageIds = append(describeImagesInput.ImageIds, &ecr.ImageIdentifier{ImageDigest: i.ImageDigest})
-	}
-	if i.ImageTags != nil {
+	} else if i.ImageTags != nil {
 		for _, tag := range *i.ImageTags {
 			describeImagesInput.ImageIds = append(describeImagesInput.ImageIds, &ecr.ImageIdentifier{ImageTag: tag})
 		}
@@ -0,0 +1,172 @@
+package ecr
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/ecr"
+	"github.com/aws/aws-sdk-go/service/ecr/ecriface"
+)
+
+// fakeECR is an in-memory ECR holding the tags and digests of each
+// repository, by registry/repository.
+type fakeECR struct {
+	ecriface.ECRAPI
+
+	mu     sync.Mutex
+	images map[string][]*ecr.ImageDetail
+}
+
+func newFakeECR() *fakeECR {
+	return &fakeECR{images: map[string][]*ecr.ImageDetail{}}
+}
+
+// key defaults the registry to the one of the caller, 123456789012.
+func (f *fakeECR) key(registry *string, repository string) string {
+	if aws.StringValue(registry) == "" {
+		return "123456789012/" + repository
+	}
+
+	return *registry + "/" + repository
+}
+
+func (f *fakeECR) push(repository, digest string, tags ...string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	key := f.key(nil, repository)
+	f.images[key] = append(f.images[key], &ecr.ImageDetail{
+		RegistryId:     aws.String("123456789012"),
+		RepositoryName: aws.String(repository),
+		ImageDigest:    aws.String(digest),
+		ImageTags:      aws.StringSlice(tags),
+	})
+}
+
+func (f *fakeECR) DescribeImagesWithContext(ctx aws.Context, in *ecr.DescribeImagesInput, opts ...request.Option) (*ecr.DescribeImagesOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	images, ok := f.images[f.key(in.RegistryId, *in.RepositoryName)]
+	if !ok {
+		return nil, awserr.New(ecr.ErrCodeRepositoryNotFoundException, "The repository does not exist.", nil)
+	}
+
+	out := &ecr.DescribeImagesOutput{}
+	if len(in.ImageIds) == 0 {
+		out.ImageDetails = images
+		return out, nil
+	}
+
+	for _, id := range in.ImageIds {
+		var found *ecr.ImageDetail
+		for _, img := range images {
+			if id.ImageDigest != nil && *id.ImageDigest == *img.ImageDigest {
+				found = img
+			}
+			for _, tag := range img.ImageTags {
+				if id.ImageTag != nil && *id.ImageTag == *tag {
+					found = img
+				}
+			}
+		}
+		if found == nil {
+			return nil, awserr.New(ecr.ErrCodeImageNotFoundException, "The image requested does not exist.", nil)
+		}
+		out.ImageDetails = append(out.ImageDetails, found)
+	}
+
+	return out, nil
+}
+
+func TestFind(t *testing.T) {
+	f := newFakeECR()
+	f.push("web", "sha256:aaa", "v1")
+	f.push("web", "sha256:bbb", "v2", "latest")
+
+	tests := []struct {
+		name    string
+		image   Image
+		digests []string
+		err     string
+	}{
+		{name: "by tag", image: Image{RepositoryName: "web", ImageTags: &[]*string{aws.String("v2")}}, digests: []string{"sha256:bbb"}},
+		{name: "by digest", image: Image{RepositoryName: "web", ImageDigest: aws.String("sha256:aaa")}, digests: []string{"sha256:aaa"}},
+		{name: "all images", image: Image{RepositoryName: "web"}, digests: []string{"sha256:aaa", "sha256:bbb"}},
+		{name: "missing tag", image: Image{RepositoryName: "web", ImageTags: &[]*string{aws.String("v3")}}, err: ecr.ErrCodeImageNotFoundException},
+		{name: "missing repository", image: Image{RepositoryName: "api"}, err: ecr.ErrCodeRepositoryNotFoundException},
+		{name: "no repository", image: Image{}, err: "Image must have a repository"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			imgs, err := tt.image.Find(f)
+			if tt.err != "" {
+				if err == nil {
+					t.Fatalf("Find() found %d images, want %s", len(imgs), tt.err)
+				}
+				if aerr, ok := err.(awserr.Error); ok && aerr.Code() != tt.err || !ok && err.Error() != tt.err {
+					t.Fatalf("Find() = %v, want %s", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			digests := []string{}
+			for _, img := range imgs {
+				digests = append(digests, *img.ImageDigest)
+			}
+			if len(digests) != len(tt.digests) {
+				t.Fatalf("Find() = %v, want %v", digests, tt.digests)
+			}
+			for i := range digests {
+				if digests[i] != tt.digests[i] {
+					t.Errorf("Find() = %v, want %v", digests, tt.digests)
+				}
+			}
+		})
+	}
+}
+
+func TestWaitForImage(t *testing.T) {
+	f := newFakeECR()
+	f.push("web", "sha256:aaa", "v1")
+
+	p := &ImagePlugin{ECR: f, Image: Image{RepositoryName: "web", ImageTags: &[]*string{aws.String("v1")}}}
+	if err := p.WaitForImage(1, 2); err != nil {
+		t.Fatal(err)
+	}
+	if p.Result.Status != StatusFound || p.Result.ImageDigest != "sha256:aaa" {
+		t.Errorf("Result = %s %s, want %s sha256:aaa", p.Result.Status, p.Result.ImageDigest, StatusFound)
+	}
+
+	p = &ImagePlugin{ECR: f, Image: Image{RepositoryName: "web", ImageTags: &[]*string{aws.String("v2")}}}
+	if err := p.WaitForImage(1, 1); err == nil {
+		t.Fatal("WaitForImage() found a missing image")
+	}
+	if p.Result.Status != StatusFailed {
+		t.Errorf("Result.Status = %s, want %s", p.Result.Status, StatusFailed)
+	}
+}
+
+func TestWaitForImageCancel(t *testing.T) {
+	f := newFakeECR()
+	f.push("web", "sha256:aaa", "v1")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(100*time.Millisecond, cancel)
+
+	p := &ImagePlugin{ECR: f, Image: Image{RepositoryName: "web", ImageTags: &[]*string{aws.String("v2")}}}
+	err := p.WaitForImageWithContext(ctx, 1, 5)
+	if err == nil || !strings.Contains(err.Error(), "Cancelled after") {
+		t.Fatalf("WaitForImageWithContext() = %v, want cancelled", err)
+	}
+}
@@ -0,0 +1,108 @@
+package ecr
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ecr"
+)
+
+// Final statuses of an ImageResult.
+const (
+	StatusFound     = "found"
+	StatusFailed    = "failed"
+	StatusCancelled = "cancelled"
+)
+
+// Statuses of a PromoteResult, besides failed and cancelled.
+const (
+	StatusPromoted  = "promoted"
+	StatusUnchanged = "unchanged"
+)
+
+// ImageResult describes the image found in ECR, for the pipeline steps that
+// follow the check.
+type ImageResult struct {
+	Image            string         `json:"image"`
+	RegistryId       string         `json:"registryId,omitempty"`
+	RepositoryName   string         `json:"repositoryName"`
+	ImageDigest      string         `json:"imageDigest,omitempty"`
+	ImageTags        []string       `json:"imageTags,omitempty"`
+	ImageSizeInBytes int64          `json:"imageSizeInBytes,omitempty"`
+	ImagePushedAt    *time.Time     `json:"imagePushedAt,omitempty"`
+	Scan             *ScanResult    `json:"scan,omitempty"`
+	Promotion        *PromoteResult `json:"promotion,omitempty"`
+	Status           string         `json:"status"`
+	Error            string         `json:"error,omitempty"`
+	StartedAt        time.Time      `json:"startedAt"`
+	DurationSeconds  float64        `json:"durationSeconds"`
+}
+
+func (r *ImageResult) finish(ctx aws.Context, i *Image, img *ecr.ImageDetail, err error) {
+	r.Image = i.DockerTag()
+	r.RegistryId = aws.StringValue(i.RegistryId)
+	r.RepositoryName = i.RepositoryName
+	if img != nil {
+		r.RegistryId = aws.StringValue(img.RegistryId)
+		r.ImageDigest = aws.StringValue(img.ImageDigest)
+		r.ImageTags = aws.StringValueSlice(img.ImageTags)
+		r.ImageSizeInBytes = aws.Int64Value(img.ImageSizeInBytes)
+		r.ImagePushedAt = img.ImagePushedAt
+	}
+
+	switch true {
+	case err == nil:
+		r.Status = StatusFound
+	case ctx.Err() == context.Canceled:
+		r.Status = StatusCancelled
+	default:
+		r.Status = StatusFailed
+	}
+	if err != nil {
+		r.Error = strings.TrimSpace(err.Error())
+	}
+	r.DurationSeconds = time.Now().Sub(r.StartedAt).Seconds()
+}
+
+// ImagesResult describes a wait for several images, with the result of each
+// image and the ones that were missing.
+type ImagesResult struct {
+	Images          []*ImageResult `json:"images"`
+	Missing         []string       `json:"missing,omitempty"`
+	Status          string         `json:"status"`
+	Error           string         `json:"error,omitempty"`
+	StartedAt       time.Time      `json:"startedAt"`
+	DurationSeconds float64        `json:"durationSeconds"`
+}
+
+func (r *ImagesResult) finish(ctx aws.Context, err error) {
+	r.Status = StatusFound
+	if err != nil {
+		r.Status = StatusFailed
+		if ctx.Err() == context.Canceled {
+			r.Status = StatusCancelled
+		}
+		r.Error = strings.TrimSpace(err.Error())
+	}
+	r.DurationSeconds = time.Now().Sub(r.StartedAt).Seconds()
+}
+
+// fail records an error of ScanImage, which keeps the image found before it.
+func (r *ImageResult) fail(ctx aws.Context, err error) {
+	r.Status = StatusFailed
+	if ctx.Err() == context.Canceled {
+		r.Status = StatusCancelled
+	}
+	r.Error = strings.TrimSpace(err.Error())
+}
+
+// PromoteResult describes the tag written by Promote.
+type PromoteResult struct {
+	Source       string `json:"source"`
+	Target       string `json:"target"`
+	ImageDigest  string `json:"imageDigest"`
+	CopiedLayers int    `json:"copiedLayers"`
+	Status       string `json:"status"`
+}
@@ -0,0 +1,339 @@
+package ecr
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/ecr"
+	"github.com/aws/aws-sdk-go/service/ecr/ecriface"
+
+	"github.com/carash/ecs-deploy/logger"
+)
+
+// Formats of the findings summary.
+const (
+	SummaryTable = "table"
+	SummaryJSON  = "json"
+)
+
+// severities lists the finding severities from the most to the least severe.
+var severities = []string{
+	ecr.FindingSeverityCritical,
+	ecr.FindingSeverityHigh,
+	ecr.FindingSeverityMedium,
+	ecr.FindingSeverityLow,
+	ecr.FindingSeverityInformational,
+	ecr.FindingSeverityUndefined,
+}
+
+// ScanPolicy gates an image on the findings of its vulnerability scan.
+type ScanPolicy struct {
+	// Trigger starts a scan with StartImageScan, instead of waiting for the
+	// scan on push
+	Trigger bool
+
+	// MaxFindings is the number of findings allowed per severity, such as
+	// CRITICAL or HIGH. Severities that are not listed are not limited.
+	MaxFindings map[string]int64
+	// Allowlist holds accepted finding names, such as CVE IDs, which are not
+	// counted against MaxFindings
+	Allowlist []string
+
+	// Summary is the format of the findings summary, table by default
+	Summary string
+}
+
+func (sp *ScanPolicy) isValid() error {
+	for severity, max := range sp.MaxFindings {
+		if !isSeverity(severity) {
+			return fmt.Errorf("Unknown finding severity [%s], must be one of %s", severity, strings.Join(severities, ", "))
+		}
+		if max < 0 {
+			return fmt.Errorf("Maximum findings of [%s] cannot be negative", severity)
+		}
+	}
+	switch sp.Summary {
+	case "", SummaryTable, SummaryJSON:
+	default:
+		return fmt.Errorf("Unknown summary format [%s]", sp.Summary)
+	}
+
+	return nil
+}
+
+func isSeverity(severity string) bool {
+	for _, s := range severities {
+		if s == severity {
+			return true
+		}
+	}
+
+	return false
+}
+
+// ScanResult summarizes the findings of an image scan.
+type ScanResult struct {
+	Status      string           `json:"status"`
+	Counts      map[string]int64 `json:"counts"`
+	Allowed     int64            `json:"allowed"`
+	MaxFindings map[string]int64 `json:"maxFindings,omitempty"`
+	Findings    []ScanFinding    `json:"findings"`
+}
+
+// ScanFinding is a finding counted against the policy.
+type ScanFinding struct {
+	Name     string `json:"name"`
+	Severity string `json:"severity"`
+	Package  string `json:"package,omitempty"`
+	URI      string `json:"uri,omitempty"`
+}
+
+// ScanImage waits for the scan findings of the image found by FindImage or
+// WaitForImage, and fails when they exceed Scan.MaxFindings.
+func (p *ImagePlugin) ScanImage(interval, timeout int64) error {
+	return p.ScanImageWithContext(aws.BackgroundContext(), interval, timeout)
+}
+
+// ScanImageWithContext is the same as ScanImage, but stops waiting once ctx
+// is cancelled.
+func (p *ImagePlugin) ScanImageWithContext(ctx aws.Context, interval, timeout int64) error {
+	if p.Result == nil {
+		p.Result = &ImageResult{StartedAt: time.Now()}
+	}
+
+	err := p.scanImage(ctx, interval, timeout)
+	if err != nil {
+		p.Result.fail(ctx, err)
+	}
+	p.Result.DurationSeconds = time.Now().Sub(p.Result.StartedAt).Seconds()
+	return err
+}
+
+func (p *ImagePlugin) scanImage(ctx aws.Context, interval, timeout int64) error {
+	if p.Scan == nil {
+		return fmt.Errorf("Image cannot be scanned without a scan policy")
+	}
+	if err := p.Scan.isValid(); err != nil {
+		return err
+	}
+	if err := p.Image.isValid(); err != nil {
+		return err
+	}
+
+	reg, err := p.newECR()
+	if err != nil {
+		return err
+	}
+
+	id := &ecr.ImageIdentifier{ImageDigest: p.Image.ImageDigest}
+	if p.Result.ImageDigest != "" {
+		id.ImageDigest = aws.String(p.Result.ImageDigest)
+	}
+	if id.ImageDigest == nil {
+		if p.Image.ImageTags == nil || len(*p.Image.ImageTags) != 1 {
+			return fmt.Errorf("Image must have a digest or a single tag to be scanned")
+		}
+		id.ImageTag = (*p.Image.ImageTags)[0]
+	}
+
+	if p.Scan.Trigger {
+		_, err := reg.StartImageScanWithContext(ctx, &ecr.StartImageScanInput{
+			RegistryId:     p.Image.RegistryId,
+			RepositoryName: &p.Image.RepositoryName,
+			ImageId:        id,
+		})
+		if aerr, ok := err.(awserr.Error); ok && aerr.Code() == ecr.ErrCodeLimitExceededException {
+			p.log().Printf("Image [%s] was already scanned today, using the latest scan\n", p.Image.DockerTag())
+		} else if err != nil {
+			return err
+		} else {
+			p.log().Printf("Started scan of Image [%s]\n", p.Image.DockerTag())
+		}
+	}
+
+	findings, status, err := p.waitForScan(ctx, reg, id, interval, timeout)
+	if err != nil {
+		return err
+	}
+
+	result := p.Scan.evaluate(status, findings)
+	p.Result.Scan = result
+	p.printScanSummary(result)
+
+	exceeded := []string{}
+	for _, severity := range severities {
+		max, ok := p.Scan.MaxFindings[severity]
+		if ok && result.Counts[severity] > max {
+			exceeded = append(exceeded, fmt.Sprintf("%d %s findings, more than the %d allowed", result.Counts[severity], severity, max))
+		}
+	}
+	if len(exceeded) > 0 {
+		return fmt.Errorf("Image [%s] has %s", p.Image.DockerTag(), strings.Join(exceeded, " and "))
+	}
+
+	return nil
+}
+
+// waitForScan polls the scan status until it is complete, and returns all
+// pages of its findings.
+func (p *ImagePlugin) waitForScan(ctx aws.Context, reg ecriface.ECRAPI, id *ecr.ImageIdentifier, interval, timeout int64) ([]ScanFinding, string, error) {
+	ctx, cancel := context.WithTimeout(ctx, time.Duration(timeout)*time.Second)
+	defer cancel()
+
+	start := time.Now()
+	ticker := time.NewTicker(pollInterval(interval))
+	defer ticker.Stop()
+
+	input := &ecr.DescribeImageScanFindingsInput{
+		RegistryId:     p.Image.RegistryId,
+		RepositoryName: &p.Image.RepositoryName,
+		ImageId:        id,
+	}
+	for {
+		findings := []ScanFinding{}
+		status := ""
+		err := reg.DescribeImageScanFindingsPagesWithContext(ctx, input, func(out *ecr.DescribeImageScanFindingsOutput, last bool) bool {
+			if out.ImageScanStatus != nil {
+				status = aws.StringValue(out.ImageScanStatus.Status)
+			}
+			if status != ecr.ScanStatusComplete && status != ecr.ScanStatusActive {
+				return false
+			}
+			if out.ImageScanFindings != nil {
+				findings = append(findings, scanFindings(out.ImageScanFindings)...)
+			}
+			return true
+		})
+		if err != nil && ctx.Err() == nil {
+			if aerr, ok := err.(awserr.Error); !ok || aerr.Code() != ecr.ErrCodeScanNotFoundException {
+				return nil, "", err
+			}
+		}
+
+		switch status {
+		case ecr.ScanStatusComplete, ecr.ScanStatusActive:
+			p.log().Printf("Scan of Image [%s] completed after %d seconds\n", p.Image.DockerTag(), int64(time.Now().Sub(start).Seconds()))
+			return findings, status, nil
+		case ecr.ScanStatusFailed, ecr.ScanStatusUnsupportedImage, ecr.ScanStatusScanEligibilityExpired, ecr.ScanStatusFindingsUnavailable:
+			return nil, status, fmt.Errorf("Scan of Image [%s] ended with status %s", p.Image.DockerTag(), status)
+		}
+
+		select {
+		case <-ctx.Done():
+			elapsed := int64(time.Now().Sub(start).Seconds())
+			if ctx.Err() == context.DeadlineExceeded {
+				return nil, "", fmt.Errorf("Timed out after %ds while waiting for the scan of Image [%s]", elapsed, p.Image.DockerTag())
+			}
+			return nil, "", fmt.Errorf("Cancelled after %ds while waiting for the scan of Image [%s]", elapsed, p.Image.DockerTag())
+		case <-ticker.C:
+			p.log().Printf("Waiting for the scan of Image [%s], %ds...\n", p.Image.DockerTag(), int64(time.Now().Sub(start).Seconds()))
+		}
+	}
+}
+
+// scanFindings reads both basic and enhanced (Inspector) findings.
+func scanFindings(sf *ecr.ImageScanFindings) []ScanFinding {
+	findings := []ScanFinding{}
+	for _, f := range sf.Findings {
+		finding := ScanFinding{
+			Name:     aws.StringValue(f.Name),
+			Severity: aws.StringValue(f.Severity),
+			URI:      aws.StringValue(f.Uri),
+		}
+		for _, a := range f.Attributes {
+			if aws.StringValue(a.Key) == "package_name" {
+				finding.Package = aws.StringValue(a.Value)
+			}
+		}
+		findings = append(findings, finding)
+	}
+	for _, f := range sf.EnhancedFindings {
+		finding := ScanFinding{Name: aws.StringValue(f.Title), Severity: aws.StringValue(f.Severity)}
+		if d := f.PackageVulnerabilityDetails; d != nil {
+			finding.Name = aws.StringValue(d.VulnerabilityId)
+			finding.URI = aws.StringValue(d.SourceUrl)
+			if len(d.VulnerablePackages) > 0 {
+				finding.Package = aws.StringValue(d.VulnerablePackages[0].Name)
+			}
+		}
+		findings = append(findings, finding)
+	}
+
+	return findings
+}
+
+// evaluate counts the findings per severity, leaving out the allowlisted
+// ones.
+func (sp *ScanPolicy) evaluate(status string, findings []ScanFinding) *ScanResult {
+	allowed := map[string]bool{}
+	for _, name := range sp.Allowlist {
+		allowed[name] = true
+	}
+
+	result := &ScanResult{
+		Status:      status,
+		Counts:      map[string]int64{},
+		MaxFindings: sp.MaxFindings,
+		Findings:    []ScanFinding{},
+	}
+	for _, f := range findings {
+		if allowed[f.Name] {
+			result.Allowed++
+			continue
+		}
+		result.Counts[f.Severity]++
+		result.Findings = append(result.Findings, f)
+	}
+
+	rank := map[string]int{}
+	for i, s := range severities {
+		rank[s] = i
+	}
+	sort.SliceStable(result.Findings, func(i, j int) bool {
+		return rank[result.Findings[i].Severity] < rank[result.Findings[j].Severity]
+	})
+
+	return result
+}
+
+func (p *ImagePlugin) printScanSummary(result *ScanResult) {
+	if p.Scan.Summary == SummaryJSON {
+		b, _ := json.Marshal(result)
+		p.log().Printf("%s\n", b)
+		return
+	}
+
+	printScanTable(p.log(), p.Image.DockerTag(), result)
+}
+
+func printScanTable(log logger.Logger, image string, result *ScanResult) {
+	var sb strings.Builder
+	w := tabwriter.NewWriter(&sb, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(w, "SEVERITY\tFINDINGS\tMAX\n")
+	for _, s := range severities {
+		max := "-"
+		if m, ok := result.MaxFindings[s]; ok {
+			max = fmt.Sprint(m)
+		}
+		fmt.Fprintf(w, "%s\t%d\t%s\n", s, result.Counts[s], max)
+	}
+	w.Flush()
+
+	log.Printf("Findings of Image [%s], %d allowlisted:\n", image, result.Allowed)
+	for _, line := range strings.Split(strings.TrimRight(sb.String(), "\n"), "\n") {
+		log.Printf("%s\n", line)
+	}
+	for _, f := range result.Findings {
+		if _, ok := result.MaxFindings[f.Severity]; ok {
+			log.Printf("  %s %s %s\n", f.Severity, f.Name, f.Package)
+		}
+	}
+	log.Printf("\n")
+}
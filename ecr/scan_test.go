@@ -0,0 +1,164 @@
+package ecr
+
+import (
+	"io/ioutil"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/ecr"
+
+	"github.com/carash/ecs-deploy/logger"
+)
+
+// fakeScanECR completes a scan after a number of polls, returning findings
+// split over two pages.
+type fakeScanECR struct {
+	*fakeECR
+
+	started  bool
+	pending  int
+	findings []*ecr.ImageScanFinding
+	enhanced []*ecr.EnhancedImageScanFinding
+}
+
+func (f *fakeScanECR) StartImageScanWithContext(ctx aws.Context, in *ecr.StartImageScanInput, opts ...request.Option) (*ecr.StartImageScanOutput, error) {
+	if f.started {
+		return nil, awserr.New(ecr.ErrCodeLimitExceededException, "The image was already scanned today.", nil)
+	}
+	f.started = true
+	return &ecr.StartImageScanOutput{ImageScanStatus: &ecr.ImageScanStatus{Status: aws.String(ecr.ScanStatusInProgress)}}, nil
+}
+
+func (f *fakeScanECR) DescribeImageScanFindingsPagesWithContext(ctx aws.Context, in *ecr.DescribeImageScanFindingsInput, fn func(*ecr.DescribeImageScanFindingsOutput, bool) bool, opts ...request.Option) error {
+	if !f.started {
+		return awserr.New(ecr.ErrCodeScanNotFoundException, "The image has not been scanned.", nil)
+	}
+	if f.pending > 0 {
+		f.pending--
+		fn(&ecr.DescribeImageScanFindingsOutput{ImageScanStatus: &ecr.ImageScanStatus{Status: aws.String(ecr.ScanStatusInProgress)}}, true)
+		return nil
+	}
+
+	complete := &ecr.ImageScanStatus{Status: aws.String(ecr.ScanStatusComplete)}
+	if !fn(&ecr.DescribeImageScanFindingsOutput{
+		ImageScanStatus:   complete,
+		ImageScanFindings: &ecr.ImageScanFindings{Findings: f.findings},
+	}, false) {
+		return nil
+	}
+	fn(&ecr.DescribeImageScanFindingsOutput{
+		ImageScanStatus:   complete,
+		ImageScanFindings: &ecr.ImageScanFindings{EnhancedFindings: f.enhanced},
+	}, true)
+	return nil
+}
+
+func finding(name, severity string) *ecr.ImageScanFinding {
+	return &ecr.ImageScanFinding{Name: aws.String(name), Severity: aws.String(severity)}
+}
+
+func TestScanImage(t *testing.T) {
+	findings := []*ecr.ImageScanFinding{
+		finding("CVE-2021-0001", ecr.FindingSeverityCritical),
+		finding("CVE-2021-0002", ecr.FindingSeverityHigh),
+		finding("CVE-2021-0003", ecr.FindingSeverityHigh),
+		finding("CVE-2021-0004", ecr.FindingSeverityLow),
+	}
+	enhanced := []*ecr.EnhancedImageScanFinding{{
+		Severity: aws.String(ecr.FindingSeverityHigh),
+		PackageVulnerabilityDetails: &ecr.PackageVulnerabilityDetails{
+			VulnerabilityId:    aws.String("CVE-2021-0005"),
+			VulnerablePackages: []*ecr.VulnerablePackage{{Name: aws.String("openssl")}},
+		},
+	}}
+
+	tests := []struct {
+		name    string
+		policy  ScanPolicy
+		started bool
+		pending int
+		counts  map[string]int64
+		allowed int64
+		err     string
+	}{
+		{
+			name:    "under thresholds",
+			policy:  ScanPolicy{Trigger: true, MaxFindings: map[string]int64{"CRITICAL": 1, "HIGH": 3}},
+			counts:  map[string]int64{"CRITICAL": 1, "HIGH": 3, "LOW": 1},
+			pending: 1,
+		},
+		{
+			name:   "over thresholds",
+			policy: ScanPolicy{Trigger: true, MaxFindings: map[string]int64{"CRITICAL": 0, "HIGH": 1}, Summary: SummaryJSON},
+			counts: map[string]int64{"CRITICAL": 1, "HIGH": 3, "LOW": 1},
+			err:    "1 CRITICAL findings, more than the 0 allowed and 3 HIGH findings, more than the 1 allowed",
+		},
+		{
+			name:    "allowlist",
+			policy:  ScanPolicy{MaxFindings: map[string]int64{"CRITICAL": 0, "HIGH": 1}, Allowlist: []string{"CVE-2021-0001", "CVE-2021-0002", "CVE-2021-0005"}},
+			started: true,
+			counts:  map[string]int64{"HIGH": 1, "LOW": 1},
+			allowed: 3,
+		},
+		{
+			name:    "scanned today",
+			policy:  ScanPolicy{Trigger: true, MaxFindings: map[string]int64{"LOW": 0}},
+			started: true,
+			counts:  map[string]int64{"CRITICAL": 1, "HIGH": 3, "LOW": 1},
+			err:     "1 LOW findings",
+		},
+		{
+			name:   "unknown severity",
+			policy: ScanPolicy{MaxFindings: map[string]int64{"SEVERE": 0}},
+			err:    "Unknown finding severity [SEVERE]",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := &fakeScanECR{fakeECR: newFakeECR(), started: tt.started, pending: tt.pending, findings: findings, enhanced: enhanced}
+			f.push("web", "sha256:aaa", "v1")
+
+			p := &ImagePlugin{
+				ECR:    f,
+				Logger: logger.NewText(ioutil.Discard),
+				Image:  Image{RepositoryName: "web", ImageTags: &[]*string{aws.String("v1")}},
+				Scan:   &tt.policy,
+			}
+			if err := p.FindImage(); err != nil {
+				t.Fatal(err)
+			}
+
+			err := p.ScanImage(1, 5)
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("ScanImage() = %v, want %q", err, tt.err)
+				}
+				if p.Result.Status != StatusFailed {
+					t.Errorf("Result.Status = %s, want %s", p.Result.Status, StatusFailed)
+				}
+			} else if err != nil {
+				t.Fatal(err)
+			}
+			if tt.counts == nil {
+				return
+			}
+
+			if p.Result.Scan == nil {
+				t.Fatal("Result.Scan is not set")
+			}
+			for _, severity := range severities {
+				if p.Result.Scan.Counts[severity] != tt.counts[severity] {
+					t.Errorf("Counts = %v, want %v", p.Result.Scan.Counts, tt.counts)
+					break
+				}
+			}
+			if p.Result.Scan.Allowed != tt.allowed {
+				t.Errorf("Allowed = %d, want %d", p.Result.Scan.Allowed, tt.allowed)
+			}
+		})
+	}
+}
@@ -0,0 +1,90 @@
+package ecr
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ecr/ecriface"
+)
+
+// waitForImages polls Images concurrently until they are all found, with a
+// client per region. An error other than a missing image stops the other
+// polls.
+func (p *ImagePlugin) waitForImages(ctx aws.Context, interval, timeout int64) error {
+	p.ImagesResult = &ImagesResult{StartedAt: time.Now(), Images: []*ImageResult{}}
+
+	err := p.waitForAll(ctx, interval, timeout)
+	p.ImagesResult.finish(ctx, err)
+	return err
+}
+
+func (p *ImagePlugin) waitForAll(ctx aws.Context, interval, timeout int64) error {
+	clients := map[string]ecriface.ECRAPI{}
+	for _, image := range p.Images {
+		if _, ok := clients[image.Region]; ok {
+			continue
+		}
+		reg, err := p.newRegionalECR(image.Region)
+		if err != nil {
+			return err
+		}
+		clients[image.Region] = reg
+	}
+
+	wctx, cancel := context.WithTimeout(ctx, time.Duration(timeout)*time.Second)
+	defer cancel()
+
+	p.log().Printf("Waiting for %d images...\n", len(p.Images))
+	start := time.Now()
+	errs := make([]error, len(p.Images))
+	failed := make([]bool, len(p.Images))
+	results := make([]*ImageResult, len(p.Images))
+	var wg sync.WaitGroup
+	for i := range p.Images {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+
+			results[i] = &ImageResult{StartedAt: start}
+			img, err := p.waitFor(wctx, clients[p.Images[i].Region], &p.Images[i], interval)
+			// errors before the deadline are not about a missing image
+			if err != nil && wctx.Err() == nil {
+				failed[i] = true
+				cancel()
+			}
+			errs[i] = err
+			results[i].finish(ctx, &p.Images[i], img, err)
+		}(i)
+	}
+	wg.Wait()
+
+	p.ImagesResult.Images = results
+	for i, err := range errs {
+		if failed[i] {
+			return err
+		}
+	}
+
+	missing := []string{}
+	for i, err := range errs {
+		if err != nil {
+			missing = append(missing, fmt.Sprintf("[%s]", p.Images[i].DockerTag()))
+			p.ImagesResult.Missing = append(p.ImagesResult.Missing, p.Images[i].DockerTag())
+		}
+	}
+
+	elapsed := int64(time.Now().Sub(start).Seconds())
+	if len(missing) > 0 {
+		if ctx.Err() != nil {
+			return fmt.Errorf("Cancelled after %ds while waiting for Images %s", elapsed, strings.Join(missing, ", "))
+		}
+		return fmt.Errorf("Timed out after %ds while waiting for Images %s", elapsed, strings.Join(missing, ", "))
+	}
+
+	p.log().Printf("All %d images found after %d seconds\n\n", len(p.Images), elapsed)
+	return nil
+}
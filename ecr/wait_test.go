@@ -0,0 +1,121 @@
+package ecr
+
+import (
+	"io/ioutil"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ecr"
+
+	cred "github.com/carash/ecs-deploy/credential"
+	"github.com/carash/ecs-deploy/logger"
+)
+
+func tagged(repository, tag string) Image {
+	return Image{RepositoryName: repository, ImageTags: &[]*string{aws.String(tag)}}
+}
+
+func TestWaitForImages(t *testing.T) {
+	tests := []struct {
+		name    string
+		images  []Image
+		missing []string
+		err     string
+	}{
+		{
+			name:   "all found",
+			images: []Image{tagged("web", "v1"), tagged("api", "v1"), tagged("worker", "v1")},
+		},
+		{
+			name:    "some missing",
+			images:  []Image{tagged("web", "v1"), tagged("web", "v9"), tagged("api", "v9")},
+			missing: []string{"web:v9", "api:v9"},
+			err:     "Timed out after 2s while waiting for Images [web:v9], [api:v9]",
+		},
+		{
+			name:   "missing repository",
+			images: []Image{tagged("web", "v9"), tagged("cron", "v1")},
+			err:    "The repository does not exist",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := newFakeECR()
+			f.push("web", "sha256:aaa", "v1")
+			f.push("api", "sha256:bbb", "v0")
+			f.push("worker", "sha256:eee", "v0")
+			time.AfterFunc(500*time.Millisecond, func() {
+				f.push("api", "sha256:ccc", "v1")
+				f.push("worker", "sha256:ddd", "v1")
+			})
+
+			p := &ImagePlugin{ECR: f, Logger: logger.NewText(ioutil.Discard), Images: tt.images}
+			start := time.Now()
+			err := p.WaitForImage(1, 2)
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("WaitForImage() = %v, want %q", err, tt.err)
+				}
+				if p.ImagesResult.Status != StatusFailed {
+					t.Errorf("Status = %s, want %s", p.ImagesResult.Status, StatusFailed)
+				}
+			} else if err != nil {
+				t.Fatal(err)
+			}
+
+			if !reflect.DeepEqual(p.ImagesResult.Missing, tt.missing) {
+				t.Errorf("Missing = %v, want %v", p.ImagesResult.Missing, tt.missing)
+			}
+			if len(p.ImagesResult.Images) != len(tt.images) {
+				t.Fatalf("Images = %d results, want %d", len(p.ImagesResult.Images), len(tt.images))
+			}
+			if tt.name == "missing repository" && time.Now().Sub(start) > time.Second {
+				t.Error("WaitForImage() kept waiting after a failure")
+			}
+		})
+	}
+}
+
+func TestNewRegionalECR(t *testing.T) {
+	p := &ImagePlugin{AWSCredential: cred.Credential{
+		AWSAccessKeyID:     "AKID",
+		AWSSecretAccessKey: "SECRET",
+		AWSRegion:          "eu-west-1",
+		AWSECREndpoint:     "http://localhost:4566",
+	}}
+
+	tests := []struct {
+		region   string
+		endpoint string
+	}{
+		{region: "", endpoint: "http://localhost:4566"},
+		{region: "eu-west-1", endpoint: "http://localhost:4566"},
+		{region: "us-east-1", endpoint: "https://api.ecr.us-east-1.amazonaws.com"},
+	}
+
+	for _, tt := range tests {
+		reg, err := p.newRegionalECR(tt.region)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if endpoint := reg.(*ecr.ECR).Endpoint; endpoint != tt.endpoint {
+			t.Errorf("newRegionalECR(%q) endpoint = %s, want %s", tt.region, endpoint, tt.endpoint)
+		}
+	}
+}
+
+func TestWaitForImageDefaultInterval(t *testing.T) {
+	f := newFakeECR()
+	f.push("web", "sha256:aaa", "v1")
+
+	for _, interval := range []int64{0, -1} {
+		p := &ImagePlugin{ECR: f, Logger: logger.NewText(ioutil.Discard), Image: tagged("web", "v1")}
+		if err := p.WaitForImage(interval, 1); err != nil {
+			t.Fatalf("WaitForImage(%d) = %v", interval, err)
+		}
+	}
+}
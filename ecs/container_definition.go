@@ -42,6 +42,24 @@ type ContainerDefinition struct {
 
 	Environment []*ecs.KeyValuePair
 	Secrets     []*ecs.Secret
+	// EnvironmentFiles are S3 objects read by the ECS agent, unlike the
+	// EnvironmentFile below
+	EnvironmentFiles []*ecs.EnvironmentFile
+
+	// MergeEnvironment and MergeSecrets set single entries by name on top of
+	// Environment and Secrets, or those of the previous revision, and the
+	// Unset lists remove entries by name. EnvironmentFile is a dotenv file
+	// and SecretsPath an SSM Parameter Store path prefix, both merged before
+	// the Merge lists; the plugins read them before registering.
+	MergeEnvironment []*ecs.KeyValuePair
+	UnsetEnvironment []string
+	MergeSecrets     []*ecs.Secret
+	UnsetSecrets     []string
+	EnvironmentFile  string
+	SecretsPath      string
+
+	loadedEnvironment []*ecs.KeyValuePair
+	loadedSecrets     []*ecs.Secret
 
 	Links        []*string
 	PortMappings []*ecs.PortMapping
@@ -62,12 +80,18 @@ func (cd *ContainerDefinition) isValid() error {
 	if cd.Name == "" {
 		return fmt.Errorf("Container Definitions must have a name")
 	}
+	if err := cd.checkVariables(); err != nil {
+		return err
+	}
 
 	return nil
 }
 
 func (cd *ContainerDefinition) generateDefinition(old *ecs.ContainerDefinition) *ecs.ContainerDefinition {
 	container := &ecs.ContainerDefinition{}
+	if old == nil || cd.Overwrite {
+		old = &ecs.ContainerDefinition{}
+	}
 
 	container.Name = &cd.Name
 
@@ -201,11 +225,18 @@ func (cd *ContainerDefinition) generateDefinition(old *ecs.ContainerDefinition)
 	} else {
 		container.Environment = old.Environment
 	}
+	container.Environment = cd.patchEnvironment(container.Environment)
 	if cd.Secrets != nil {
 		container.Secrets = cd.Secrets
 	} else {
 		container.Secrets = old.Secrets
 	}
+	container.Secrets = cd.patchSecrets(container.Secrets)
+	if cd.EnvironmentFiles != nil {
+		container.EnvironmentFiles = cd.EnvironmentFiles
+	} else {
+		container.EnvironmentFiles = old.EnvironmentFiles
+	}
 	if cd.Links != nil {
 		container.Links = cd.Links
 	} else {
@@ -0,0 +1,225 @@
+package ecs
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/carash/ecs-deploy/logger"
+)
+
+// diff returns a human readable, field level description of the changes
+// between two values of the same type. Slices of named structs (containers,
+// environment variables, tags, ...) are matched by their Name or Key field.
+func diff(old, new interface{}) []string {
+	lines := []string{}
+	diffValue("", reflect.ValueOf(old), reflect.ValueOf(new), &lines)
+	return lines
+}
+
+func printDiff(log logger.Logger, lines []string) {
+	if len(lines) == 0 {
+		log.Printf("  No changes\n")
+	}
+	for _, l := range lines {
+		log.Printf("  %s\n", l)
+	}
+	log.Printf("\n")
+}
+
+func diffValue(path string, old, new reflect.Value, lines *[]string) {
+	old, new = indirect(old), indirect(new)
+
+	switch true {
+	case !old.IsValid() && !new.IsValid():
+		return
+	case !old.IsValid():
+		*lines = append(*lines, fmt.Sprintf("+ %s: %s", path, render(new)))
+		return
+	case !new.IsValid():
+		*lines = append(*lines, fmt.Sprintf("- %s: %s", path, render(old)))
+		return
+	}
+
+	switch old.Kind() {
+	case reflect.Struct:
+		t := old.Type()
+		for i := 0; i < t.NumField(); i++ {
+			if t.Field(i).PkgPath != "" {
+				continue
+			}
+			diffValue(joinPath(path, t.Field(i).Name), old.Field(i), new.Field(i), lines)
+		}
+	case reflect.Slice:
+		if old.Len() == 0 && new.Len() == 0 {
+			return
+		}
+		if keyOf(old) == nil && keyOf(new) == nil {
+			if elemKind(old) != reflect.Struct {
+				if !reflect.DeepEqual(old.Interface(), new.Interface()) {
+					*lines = append(*lines, fmt.Sprintf("~ %s: %s -> %s", path, render(old), render(new)))
+				}
+				return
+			}
+			for i := 0; i < old.Len() || i < new.Len(); i++ {
+				diffValue(fmt.Sprintf("%s[%d]", path, i), index(old, i), index(new, i), lines)
+			}
+			return
+		}
+
+		olds, oldKeys := keyed(old)
+		news, newKeys := keyed(new)
+		for _, k := range oldKeys {
+			diffValue(fmt.Sprintf("%s[%s]", path, k), olds[k], news[k], lines)
+		}
+		for _, k := range newKeys {
+			if _, ok := olds[k]; !ok {
+				diffValue(fmt.Sprintf("%s[%s]", path, k), reflect.Value{}, news[k], lines)
+			}
+		}
+	case reflect.Map:
+		keys := map[string]bool{}
+		for _, k := range old.MapKeys() {
+			keys[k.String()] = true
+		}
+		for _, k := range new.MapKeys() {
+			keys[k.String()] = true
+		}
+		sorted := []string{}
+		for k := range keys {
+			sorted = append(sorted, k)
+		}
+		sort.Strings(sorted)
+		for _, k := range sorted {
+			key := reflect.ValueOf(k)
+			diffValue(fmt.Sprintf("%s[%s]", path, k), old.MapIndex(key), new.MapIndex(key), lines)
+		}
+	default:
+		if !reflect.DeepEqual(old.Interface(), new.Interface()) {
+			*lines = append(*lines, fmt.Sprintf("~ %s: %s -> %s", path, render(old), render(new)))
+		}
+	}
+}
+
+func indirect(v reflect.Value) reflect.Value {
+	for v.IsValid() && (v.Kind() == reflect.Ptr || v.Kind() == reflect.Interface) {
+		if v.IsNil() {
+			return reflect.Value{}
+		}
+		v = v.Elem()
+	}
+	if v.IsValid() && (v.Kind() == reflect.Slice || v.Kind() == reflect.Map) && v.IsNil() {
+		return reflect.Value{}
+	}
+
+	return v
+}
+
+func index(v reflect.Value, i int) reflect.Value {
+	if i >= v.Len() {
+		return reflect.Value{}
+	}
+
+	return v.Index(i)
+}
+
+func elemKind(v reflect.Value) reflect.Kind {
+	t := v.Type().Elem()
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+
+	return t.Kind()
+}
+
+// keyOf returns the Name or Key of the first element of a slice of structs,
+// or nil when the slice is not keyed.
+func keyOf(v reflect.Value) *string {
+	for i := 0; i < v.Len(); i++ {
+		if k, ok := elemKey(v.Index(i)); ok {
+			return &k
+		}
+	}
+
+	return nil
+}
+
+func elemKey(v reflect.Value) (string, bool) {
+	v = indirect(v)
+	if !v.IsValid() || v.Kind() != reflect.Struct {
+		return "", false
+	}
+	for _, name := range []string{"Name", "Key"} {
+		f := indirect(v.FieldByName(name))
+		if f.IsValid() && f.Kind() == reflect.String {
+			return f.String(), true
+		}
+	}
+
+	return "", false
+}
+
+func keyed(v reflect.Value) (map[string]reflect.Value, []string) {
+	values := map[string]reflect.Value{}
+	keys := []string{}
+	for i := 0; i < v.Len(); i++ {
+		k, ok := elemKey(v.Index(i))
+		if !ok {
+			k = fmt.Sprintf("%d", i)
+		}
+		values[k] = v.Index(i)
+		keys = append(keys, k)
+	}
+
+	return values, keys
+}
+
+func joinPath(path, field string) string {
+	if path == "" {
+		return field
+	}
+
+	return path + "." + field
+}
+
+func render(v reflect.Value) string {
+	if v.Kind() == reflect.String {
+		return v.String()
+	}
+
+	b, err := json.Marshal(v.Interface())
+	if err != nil {
+		return fmt.Sprintf("%v", v.Interface())
+	}
+
+	// drop unset fields so added and removed values stay readable
+	var compact interface{}
+	if err := json.Unmarshal(b, &compact); err == nil {
+		if cb, err := json.Marshal(dropNulls(compact)); err == nil {
+			b = cb
+		}
+	}
+
+	return strings.TrimSpace(string(b))
+}
+
+func dropNulls(v interface{}) interface{} {
+	switch t := v.(type) {
+	case map[string]interface{}:
+		for k, e := range t {
+			if e == nil {
+				delete(t, k)
+			} else {
+				t[k] = dropNulls(e)
+			}
+		}
+	case []interface{}:
+		for i, e := range t {
+			t[i] = dropNulls(e)
+		}
+	}
+
+	return v
+}
@@ -0,0 +1,77 @@
+package ecs
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ecs"
+)
+
+func TestDiff(t *testing.T) {
+	tests := []struct {
+		name string
+		old  interface{}
+		new  interface{}
+		want []string
+	}{
+		{
+			name: "no changes",
+			old:  &ecs.RegisterTaskDefinitionInput{Family: aws.String("web")},
+			new:  &ecs.RegisterTaskDefinitionInput{Family: aws.String("web")},
+			want: []string{},
+		},
+		{
+			name: "changed and added fields",
+			old:  &ecs.RegisterTaskDefinitionInput{Cpu: aws.String("256")},
+			new:  &ecs.RegisterTaskDefinitionInput{Cpu: aws.String("512"), Memory: aws.String("1024")},
+			want: []string{"~ Cpu: 256 -> 512", "+ Memory: 1024"},
+		},
+		{
+			name: "containers by name",
+			old: &ecs.RegisterTaskDefinitionInput{ContainerDefinitions: []*ecs.ContainerDefinition{
+				{Name: aws.String("app"), Image: aws.String("app:1")},
+				{Name: aws.String("proxy"), Image: aws.String("proxy:1")},
+			}},
+			new: &ecs.RegisterTaskDefinitionInput{ContainerDefinitions: []*ecs.ContainerDefinition{
+				{Name: aws.String("worker"), Image: aws.String("worker:1")},
+				{Name: aws.String("app"), Image: aws.String("app:2")},
+			}},
+			want: []string{
+				"~ ContainerDefinitions[app].Image: app:1 -> app:2",
+				`- ContainerDefinitions[proxy]: {"Image":"proxy:1","Name":"proxy"}`,
+				`+ ContainerDefinitions[worker]: {"Image":"worker:1","Name":"worker"}`,
+			},
+		},
+		{
+			name: "environment by name",
+			old: &ecs.ContainerDefinition{Environment: []*ecs.KeyValuePair{
+				{Name: aws.String("A"), Value: aws.String("1")},
+			}},
+			new: &ecs.ContainerDefinition{Environment: []*ecs.KeyValuePair{
+				{Name: aws.String("A"), Value: aws.String("2")},
+			}},
+			want: []string{"~ Environment[A].Value: 1 -> 2"},
+		},
+		{
+			name: "unkeyed values",
+			old:  &ecs.ContainerDefinition{Command: aws.StringSlice([]string{"run"})},
+			new:  &ecs.ContainerDefinition{Command: aws.StringSlice([]string{"run", "--fast"})},
+			want: []string{`~ Command: ["run"] -> ["run","--fast"]`},
+		},
+		{
+			name: "maps by key",
+			old:  &ecs.ContainerDefinition{DockerLabels: aws.StringMap(map[string]string{"a": "1", "b": "2"})},
+			new:  &ecs.ContainerDefinition{DockerLabels: aws.StringMap(map[string]string{"a": "1", "c": "3"})},
+			want: []string{"- DockerLabels[b]: 2", "+ DockerLabels[c]: 3"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := diff(tt.old, tt.new); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("diff() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,98 @@
+package ecs
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ecs"
+	"github.com/aws/aws-sdk-go/service/ecs/ecsiface"
+
+	"github.com/carash/ecs-deploy/logger"
+)
+
+// deployWatcher reports the Service events and the stopped tasks of a
+// deployment, remembering what was already printed between polls.
+type deployWatcher struct {
+	since     time.Time
+	lastEvent time.Time
+	stopped   map[string]bool
+}
+
+func newDeployWatcher(since time.Time) *deployWatcher {
+	return &deployWatcher{
+		since:     since,
+		lastEvent: since,
+		stopped:   map[string]bool{},
+	}
+}
+
+// report prints new events and stopped tasks, and returns the number of
+// tasks of the new Task Definition that stopped since the deploy started.
+func (p *ServicePlugin) report(ctx aws.Context, svc ecsiface.ECSAPI, service *ecs.Service, w *deployWatcher) (int64, error) {
+	srv, err := describeService(ctx, svc, p.Service.Cluster, p.Service.Service)
+	if err != nil {
+		return 0, err
+	}
+
+	// events are returned newest first
+	for i := len(srv.Events) - 1; i >= 0; i-- {
+		e := srv.Events[i]
+		if e.CreatedAt == nil || !e.CreatedAt.After(w.lastEvent) {
+			continue
+		}
+		p.log().Printf("Event %s: %s\n", e.CreatedAt.Format(time.RFC3339), aws.StringValue(e.Message))
+		w.lastEvent = *e.CreatedAt
+	}
+
+	taskArns := []*string{}
+	err = svc.ListTasksPagesWithContext(ctx, &ecs.ListTasksInput{
+		Cluster:       p.Service.Cluster,
+		ServiceName:   &p.Service.Service,
+		DesiredStatus: aws.String(ecs.DesiredStatusStopped),
+	}, func(out *ecs.ListTasksOutput, last bool) bool {
+		taskArns = append(taskArns, out.TaskArns...)
+		return true
+	})
+	if err != nil {
+		return 0, err
+	}
+	if len(taskArns) > 0 {
+		tasks, err := describeTasks(ctx, svc, p.Service.Cluster, taskArns)
+		if err != nil {
+			return 0, err
+		}
+
+		for _, t := range tasks {
+			if aws.StringValue(t.TaskDefinitionArn) != *service.TaskDefinition ||
+				aws.StringValue(t.LastStatus) != ecs.DesiredStatusStopped ||
+				t.CreatedAt == nil || t.CreatedAt.Before(w.since) ||
+				w.stopped[*t.TaskArn] {
+				continue
+			}
+			w.stopped[*t.TaskArn] = true
+			printStoppedTask(p.log(), t)
+		}
+	}
+
+	return int64(len(w.stopped)), nil
+}
+
+func printStoppedTask(log logger.Logger, t *ecs.Task) {
+	td, _ := parseFamilyRevision(aws.StringValue(t.TaskDefinitionArn))
+	log.Printf("Task [%s] of [%s] STOPPED: %s\n", taskID(t), td, aws.StringValue(t.StoppedReason))
+	for _, c := range t.Containers {
+		if c.ExitCode == nil && c.Reason == nil {
+			continue
+		}
+
+		line := fmt.Sprintf("  Container [%s]", aws.StringValue(c.Name))
+		if c.ExitCode != nil {
+			line += fmt.Sprintf(" exit code %d", *c.ExitCode)
+		}
+		if c.Reason != nil {
+			line += fmt.Sprintf(": %s", *c.Reason)
+		}
+		log.Printf("%s\n", line)
+	}
+}
@@ -0,0 +1,552 @@
+package ecs
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/awsutil"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/ecs"
+	"github.com/aws/aws-sdk-go/service/ecs/ecsiface"
+)
+
+const fakeAccount = "123456789012"
+
+// fakeECS is an in-memory ECS. Every DescribeServices call advances the
+// simulation by one step: new tasks go PENDING -> RUNNING -> HEALTHY (or stay
+// UNKNOWN without a health check), crashing tasks stop and are replaced, and
+// the tasks of older deployments drain once the PRIMARY one is healthy.
+type fakeECS struct {
+	ecsiface.ECSAPI
+
+	mu sync.Mutex
+
+	taskDefinitions map[string][]*ecs.TaskDefinition
+	tags            map[string][]*ecs.Tag
+	services        map[string]*ecs.Service
+	tasks           []*ecs.Task
+
+	// crashing images exit right after they start
+	crashing map[string]bool
+	// healthCheck makes running tasks report HEALTHY instead of UNKNOWN
+	healthCheck bool
+	// partition and region of the ARNs
+	partition, region string
+
+	// runs records the RunTask calls, which start nothing when runFailures
+	// is set. The containers of run tasks stop with runExitCodes, by
+	// container name, or without an exit code when they have none.
+	runs         []*ecs.RunTaskInput
+	runFailures  []*ecs.Failure
+	runExitCodes map[string]int64
+}
+
+func newFakeECS() *fakeECS {
+	return &fakeECS{
+		taskDefinitions: map[string][]*ecs.TaskDefinition{},
+		tags:            map[string][]*ecs.Tag{},
+		services:        map[string]*ecs.Service{},
+		crashing:        map[string]bool{},
+		healthCheck:     true,
+		partition:       "aws",
+		region:          "us-east-1",
+		runExitCodes:    map[string]int64{},
+	}
+}
+
+// addService creates a Service whose tasks are already running and healthy.
+func (f *fakeECS) addService(name, image string, desired int64) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	td := f.register(&ecs.RegisterTaskDefinitionInput{
+		Family: aws.String(name),
+		ContainerDefinitions: []*ecs.ContainerDefinition{{
+			Name:  aws.String(name),
+			Image: aws.String(image),
+		}},
+	})
+	srv := &ecs.Service{
+		ServiceArn:   aws.String(fmt.Sprintf("arn:%s:ecs:%s:%s:service/%s", f.partition, f.region, fakeAccount, name)),
+		ServiceName:  aws.String(name),
+		Status:       aws.String("ACTIVE"),
+		DesiredCount: aws.Int64(desired),
+		RunningCount: aws.Int64(desired),
+	}
+	f.services[name] = srv
+	f.deploy(srv, td)
+
+	for _, t := range f.tasks {
+		t.LastStatus = aws.String(ecs.DesiredStatusRunning)
+		t.HealthStatus = aws.String(ecs.HealthStatusHealthy)
+	}
+	for _, d := range srv.Deployments {
+		d.RunningCount = aws.Int64(desired)
+		d.PendingCount = aws.Int64(0)
+		d.RolloutState = aws.String(ecs.DeploymentRolloutStateCompleted)
+	}
+}
+
+func (f *fakeECS) serviceTaskDefinition(name string) string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	td, _ := parseFamilyRevision(aws.StringValue(f.services[name].TaskDefinition))
+	return td
+}
+
+func (f *fakeECS) DescribeTaskDefinition(in *ecs.DescribeTaskDefinitionInput) (*ecs.DescribeTaskDefinitionOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	td := f.findTaskDefinition(*in.TaskDefinition)
+	if td == nil {
+		return nil, awserr.New(ecs.ErrCodeClientException, "Unable to describe task definition.", nil)
+	}
+
+	out := &ecs.DescribeTaskDefinitionOutput{TaskDefinition: td, Tags: f.tags[*td.TaskDefinitionArn]}
+	return awsutil.CopyOf(out).(*ecs.DescribeTaskDefinitionOutput), nil
+}
+
+func (f *fakeECS) RegisterTaskDefinition(in *ecs.RegisterTaskDefinitionInput) (*ecs.RegisterTaskDefinitionOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	td := f.register(in)
+	out := &ecs.RegisterTaskDefinitionOutput{TaskDefinition: td, Tags: in.Tags}
+	return awsutil.CopyOf(out).(*ecs.RegisterTaskDefinitionOutput), nil
+}
+
+func (f *fakeECS) register(in *ecs.RegisterTaskDefinitionInput) *ecs.TaskDefinition {
+	in = awsutil.CopyOf(in).(*ecs.RegisterTaskDefinitionInput)
+	revision := int64(len(f.taskDefinitions[*in.Family]) + 1)
+	td := &ecs.TaskDefinition{
+		TaskDefinitionArn:       aws.String(fmt.Sprintf("arn:%s:ecs:%s:%s:task-definition/%s:%d", f.partition, f.region, fakeAccount, *in.Family, revision)),
+		Family:                  in.Family,
+		Revision:                aws.Int64(revision),
+		Status:                  aws.String("ACTIVE"),
+		ContainerDefinitions:    in.ContainerDefinitions,
+		TaskRoleArn:             in.TaskRoleArn,
+		ExecutionRoleArn:        in.ExecutionRoleArn,
+		NetworkMode:             in.NetworkMode,
+		Volumes:                 in.Volumes,
+		RequiresCompatibilities: in.RequiresCompatibilities,
+		Cpu:                     in.Cpu,
+		Memory:                  in.Memory,
+		IpcMode:                 in.IpcMode,
+		PidMode:                 in.PidMode,
+		PlacementConstraints:    in.PlacementConstraints,
+		ProxyConfiguration:      in.ProxyConfiguration,
+		RuntimePlatform:         in.RuntimePlatform,
+		EphemeralStorage:        in.EphemeralStorage,
+		InferenceAccelerators:   in.InferenceAccelerators,
+	}
+	f.taskDefinitions[*in.Family] = append(f.taskDefinitions[*in.Family], td)
+	f.tags[*td.TaskDefinitionArn] = in.Tags
+
+	return td
+}
+
+func (f *fakeECS) findTaskDefinition(name string) *ecs.TaskDefinition {
+	if i := strings.Index(name, "/"); i >= 0 {
+		name = name[i+1:]
+	}
+
+	parts := strings.Split(name, ":")
+	revisions := f.taskDefinitions[parts[0]]
+	if len(revisions) == 0 {
+		return nil
+	}
+	if len(parts) == 1 {
+		return revisions[len(revisions)-1]
+	}
+
+	revision, _ := strconv.Atoi(parts[1])
+	if revision < 1 || revision > len(revisions) {
+		return nil
+	}
+	return revisions[revision-1]
+}
+
+func (f *fakeECS) DescribeServicesWithContext(ctx aws.Context, in *ecs.DescribeServicesInput, opts ...request.Option) (*ecs.DescribeServicesOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	out := &ecs.DescribeServicesOutput{}
+	for _, name := range in.Services {
+		srv, ok := f.services[(*name)[strings.LastIndex(*name, "/")+1:]]
+		if !ok {
+			out.Failures = append(out.Failures, &ecs.Failure{Arn: name, Reason: aws.String("MISSING")})
+			continue
+		}
+		f.step(srv)
+		out.Services = append(out.Services, srv)
+	}
+
+	return awsutil.CopyOf(out).(*ecs.DescribeServicesOutput), nil
+}
+
+func (f *fakeECS) CreateService(in *ecs.CreateServiceInput) (*ecs.CreateServiceOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if _, ok := f.services[*in.ServiceName]; ok {
+		return nil, awserr.New(ecs.ErrCodeInvalidParameterException, "Creation of service was not idempotent.", nil)
+	}
+	td := f.findTaskDefinition(aws.StringValue(in.TaskDefinition))
+	if td == nil {
+		return nil, awserr.New(ecs.ErrCodeClientException, "TaskDefinition not found.", nil)
+	}
+
+	desired := aws.Int64Value(in.DesiredCount)
+	srv := &ecs.Service{
+		ServiceArn:           aws.String(fmt.Sprintf("arn:%s:ecs:%s:%s:service/%s", f.partition, f.region, fakeAccount, *in.ServiceName)),
+		ServiceName:          in.ServiceName,
+		Status:               aws.String("ACTIVE"),
+		DesiredCount:         aws.Int64(desired),
+		RunningCount:         aws.Int64(0),
+		LaunchType:           in.LaunchType,
+		NetworkConfiguration: in.NetworkConfiguration,
+		LoadBalancers:        in.LoadBalancers,
+		Tags:                 in.Tags,
+	}
+	f.services[*in.ServiceName] = srv
+	f.deploy(srv, td)
+
+	return awsutil.CopyOf(&ecs.CreateServiceOutput{Service: srv}).(*ecs.CreateServiceOutput), nil
+}
+
+func (f *fakeECS) UpdateService(in *ecs.UpdateServiceInput) (*ecs.UpdateServiceOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	srv, ok := f.services[*in.Service]
+	if !ok {
+		return nil, awserr.New(ecs.ErrCodeServiceNotFoundException, "Service not found.", nil)
+	}
+
+	if in.DesiredCount != nil {
+		srv.DesiredCount = in.DesiredCount
+	}
+	if in.NetworkConfiguration != nil {
+		srv.NetworkConfiguration = in.NetworkConfiguration
+	}
+	td := f.findTaskDefinition(aws.StringValue(srv.TaskDefinition))
+	if in.TaskDefinition != nil {
+		if td = f.findTaskDefinition(*in.TaskDefinition); td == nil {
+			return nil, awserr.New(ecs.ErrCodeClientException, "TaskDefinition not found.", nil)
+		}
+	}
+	f.deploy(srv, td)
+
+	return awsutil.CopyOf(&ecs.UpdateServiceOutput{Service: srv}).(*ecs.UpdateServiceOutput), nil
+}
+
+// deploy starts a new PRIMARY deployment of td, and marks the previous ones
+// ACTIVE so their tasks drain. A deployment of the same revision is taken
+// over by the new one.
+func (f *fakeECS) deploy(srv *ecs.Service, td *ecs.TaskDefinition) {
+	deployments := []*ecs.Deployment{}
+	for _, d := range srv.Deployments {
+		if *d.TaskDefinition != *td.TaskDefinitionArn {
+			d.Status = aws.String("ACTIVE")
+			deployments = append(deployments, d)
+		}
+	}
+
+	srv.TaskDefinition = td.TaskDefinitionArn
+	srv.Deployments = append([]*ecs.Deployment{{
+		Id:             aws.String(fmt.Sprintf("ecs-svc/%d", len(f.tasks)+len(srv.Deployments))),
+		Status:         aws.String("PRIMARY"),
+		TaskDefinition: td.TaskDefinitionArn,
+		DesiredCount:   srv.DesiredCount,
+		RunningCount:   aws.Int64(0),
+		PendingCount:   aws.Int64(0),
+		RolloutState:   aws.String(ecs.DeploymentRolloutStateInProgress),
+		CreatedAt:      fakeNow(),
+	}}, deployments...)
+	f.event(srv, fmt.Sprintf("(service %s) has begun a deployment", *srv.ServiceName))
+	f.launch(srv, td)
+}
+
+// launch starts PENDING tasks until the PRIMARY deployment has its desired
+// count of tasks.
+func (f *fakeECS) launch(srv *ecs.Service, td *ecs.TaskDefinition) {
+	group := "service:" + *srv.ServiceName
+	active := int64(0)
+	for _, t := range f.tasks {
+		if *t.Group == group && *t.TaskDefinitionArn == *td.TaskDefinitionArn && *t.DesiredStatus == ecs.DesiredStatusRunning {
+			active++
+		}
+	}
+
+	for ; active < *srv.DesiredCount; active++ {
+		task := &ecs.Task{
+			TaskArn:           aws.String(fmt.Sprintf("arn:%s:ecs:%s:%s:task/%d", f.partition, f.region, fakeAccount, len(f.tasks)+1)),
+			TaskDefinitionArn: td.TaskDefinitionArn,
+			Group:             aws.String(group),
+			CreatedAt:         fakeNow(),
+			DesiredStatus:     aws.String(ecs.DesiredStatusRunning),
+			LastStatus:        aws.String("PENDING"),
+			HealthStatus:      aws.String(ecs.HealthStatusUnknown),
+		}
+		for _, cd := range td.ContainerDefinitions {
+			task.Containers = append(task.Containers, &ecs.Container{Name: cd.Name})
+		}
+		f.tasks = append(f.tasks, task)
+	}
+}
+
+func (f *fakeECS) step(srv *ecs.Service) {
+	group := "service:" + *srv.ServiceName
+	td := f.findTaskDefinition(*srv.TaskDefinition)
+
+	healthy := int64(0)
+	for _, t := range f.tasks {
+		if *t.Group != group || *t.DesiredStatus != ecs.DesiredStatusRunning || *t.TaskDefinitionArn != *td.TaskDefinitionArn {
+			continue
+		}
+
+		switch *t.LastStatus {
+		case "PENDING":
+			t.LastStatus = aws.String(ecs.DesiredStatusRunning)
+		case ecs.DesiredStatusRunning:
+			if f.isCrashing(td) {
+				f.stop(t, "Essential container in task exited", 1)
+				continue
+			}
+			if f.healthCheck {
+				t.HealthStatus = aws.String(ecs.HealthStatusHealthy)
+			}
+		}
+		if *t.LastStatus == ecs.DesiredStatusRunning && (!f.healthCheck || *t.HealthStatus == ecs.HealthStatusHealthy) {
+			healthy++
+		}
+	}
+
+	// older tasks drain once the new ones are up
+	if healthy >= *srv.DesiredCount {
+		for _, t := range f.tasks {
+			if *t.Group == group && *t.DesiredStatus == ecs.DesiredStatusRunning && *t.TaskDefinitionArn != *td.TaskDefinitionArn {
+				f.stop(t, "Scaling activity initiated by deployment", 0)
+			}
+		}
+	}
+	f.launch(srv, td)
+
+	running := int64(0)
+	deployments := []*ecs.Deployment{}
+	for _, d := range srv.Deployments {
+		counts := map[string]int64{}
+		for _, t := range f.tasks {
+			if *t.Group == group && *t.TaskDefinitionArn == *d.TaskDefinition && *t.DesiredStatus == ecs.DesiredStatusRunning {
+				counts[*t.LastStatus]++
+			}
+		}
+		d.RunningCount = aws.Int64(counts[ecs.DesiredStatusRunning])
+		d.PendingCount = aws.Int64(counts["PENDING"])
+		running += *d.RunningCount
+
+		if *d.Status == "PRIMARY" || *d.RunningCount+*d.PendingCount > 0 {
+			deployments = append(deployments, d)
+		}
+	}
+	srv.Deployments = deployments
+	srv.RunningCount = aws.Int64(running)
+
+	primary := srv.Deployments[0]
+	if len(srv.Deployments) == 1 && *primary.RunningCount == *srv.DesiredCount && *primary.RolloutState != ecs.DeploymentRolloutStateCompleted {
+		primary.RolloutState = aws.String(ecs.DeploymentRolloutStateCompleted)
+		f.event(srv, fmt.Sprintf("(service %s) has reached a steady state.", *srv.ServiceName))
+	}
+}
+
+func (f *fakeECS) isCrashing(td *ecs.TaskDefinition) bool {
+	for _, cd := range td.ContainerDefinitions {
+		if f.crashing[aws.StringValue(cd.Image)] {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (f *fakeECS) stop(t *ecs.Task, reason string, exitCode int64) {
+	t.DesiredStatus = aws.String(ecs.DesiredStatusStopped)
+	t.LastStatus = aws.String(ecs.DesiredStatusStopped)
+	t.StoppedReason = aws.String(reason)
+	for _, c := range t.Containers {
+		c.ExitCode = aws.Int64(exitCode)
+	}
+}
+
+func (f *fakeECS) event(srv *ecs.Service, message string) {
+	srv.Events = append([]*ecs.ServiceEvent{{
+		CreatedAt: fakeNow(),
+		Message:   aws.String(message),
+	}}, srv.Events...)
+}
+
+func (f *fakeECS) ListTasksPagesWithContext(ctx aws.Context, in *ecs.ListTasksInput, fn func(*ecs.ListTasksOutput, bool) bool, opts ...request.Option) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	desired := ecs.DesiredStatusRunning
+	if in.DesiredStatus != nil {
+		desired = *in.DesiredStatus
+	}
+
+	out := &ecs.ListTasksOutput{TaskArns: []*string{}}
+	for _, t := range f.tasks {
+		if in.ServiceName != nil && *t.Group != "service:"+*in.ServiceName {
+			continue
+		}
+		if *t.DesiredStatus == desired {
+			out.TaskArns = append(out.TaskArns, aws.String(*t.TaskArn))
+		}
+	}
+
+	fn(out, true)
+	return nil
+}
+
+func (f *fakeECS) RunTask(in *ecs.RunTaskInput) (*ecs.RunTaskOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.runs = append(f.runs, awsutil.CopyOf(in).(*ecs.RunTaskInput))
+	if len(f.runFailures) > 0 {
+		return &ecs.RunTaskOutput{Failures: f.runFailures}, nil
+	}
+	td := f.findTaskDefinition(aws.StringValue(in.TaskDefinition))
+	if td == nil {
+		return nil, awserr.New(ecs.ErrCodeClientException, "TaskDefinition not found.", nil)
+	}
+
+	task := &ecs.Task{
+		TaskArn:           aws.String(fmt.Sprintf("arn:%s:ecs:%s:%s:task/default/%d", f.partition, f.region, fakeAccount, len(f.tasks)+1)),
+		TaskDefinitionArn: td.TaskDefinitionArn,
+		Group:             aws.String("family:" + *td.Family),
+		CreatedAt:         fakeNow(),
+		DesiredStatus:     aws.String(ecs.DesiredStatusRunning),
+		LastStatus:        aws.String("PENDING"),
+		HealthStatus:      aws.String(ecs.HealthStatusUnknown),
+	}
+	for _, cd := range td.ContainerDefinitions {
+		task.Containers = append(task.Containers, &ecs.Container{Name: cd.Name})
+	}
+	f.tasks = append(f.tasks, task)
+
+	return awsutil.CopyOf(&ecs.RunTaskOutput{Tasks: []*ecs.Task{task}}).(*ecs.RunTaskOutput), nil
+}
+
+// stepRun advances a run task by one step: PENDING -> RUNNING -> STOPPED.
+func (f *fakeECS) stepRun(t *ecs.Task) {
+	switch *t.LastStatus {
+	case "PENDING":
+		t.LastStatus = aws.String(ecs.DesiredStatusRunning)
+	case ecs.DesiredStatusRunning:
+		f.stop(t, "Essential container in task exited", 0)
+		for _, c := range t.Containers {
+			c.ExitCode = nil
+			if code, ok := f.runExitCodes[*c.Name]; ok {
+				c.ExitCode = aws.Int64(code)
+			}
+		}
+	}
+}
+
+func (f *fakeECS) DescribeTasksWithContext(ctx aws.Context, in *ecs.DescribeTasksInput, opts ...request.Option) (*ecs.DescribeTasksOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	out := &ecs.DescribeTasksOutput{}
+	for _, arn := range in.Tasks {
+		for _, t := range f.tasks {
+			if *t.TaskArn == *arn {
+				if strings.HasPrefix(*t.Group, "family:") {
+					f.stepRun(t)
+				}
+				out.Tasks = append(out.Tasks, t)
+			}
+		}
+	}
+
+	return awsutil.CopyOf(out).(*ecs.DescribeTasksOutput), nil
+}
+
+func (f *fakeECS) ListServicesPagesWithContext(ctx aws.Context, in *ecs.ListServicesInput, fn func(*ecs.ListServicesOutput, bool) bool, opts ...request.Option) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	out := &ecs.ListServicesOutput{ServiceArns: []*string{}}
+	for name := range f.services {
+		out.ServiceArns = append(out.ServiceArns, aws.String(fmt.Sprintf("arn:%s:ecs:%s:%s:service/default/%s", f.partition, f.region, fakeAccount, name)))
+	}
+
+	fn(out, true)
+	return nil
+}
+
+func (f *fakeECS) ListTaskDefinitionsPagesWithContext(ctx aws.Context, in *ecs.ListTaskDefinitionsInput, fn func(*ecs.ListTaskDefinitionsOutput, bool) bool, opts ...request.Option) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	out := &ecs.ListTaskDefinitionsOutput{TaskDefinitionArns: []*string{}}
+	for family, revisions := range f.taskDefinitions {
+		if !strings.HasPrefix(family, aws.StringValue(in.FamilyPrefix)) {
+			continue
+		}
+		for i := len(revisions) - 1; i >= 0; i-- {
+			if *revisions[i].Status == aws.StringValue(in.Status) {
+				out.TaskDefinitionArns = append(out.TaskDefinitionArns, aws.String(*revisions[i].TaskDefinitionArn))
+			}
+		}
+	}
+
+	fn(out, true)
+	return nil
+}
+
+func (f *fakeECS) DeregisterTaskDefinitionWithContext(ctx aws.Context, in *ecs.DeregisterTaskDefinitionInput, opts ...request.Option) (*ecs.DeregisterTaskDefinitionOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	td := f.findTaskDefinition(*in.TaskDefinition)
+	if td == nil {
+		return nil, awserr.New(ecs.ErrCodeClientException, "Unable to describe task definition.", nil)
+	}
+	td.Status = aws.String(ecs.TaskDefinitionStatusInactive)
+
+	return &ecs.DeregisterTaskDefinitionOutput{TaskDefinition: awsutil.CopyOf(td).(*ecs.TaskDefinition)}, nil
+}
+
+func (f *fakeECS) DeleteTaskDefinitionsWithContext(ctx aws.Context, in *ecs.DeleteTaskDefinitionsInput, opts ...request.Option) (*ecs.DeleteTaskDefinitionsOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	out := &ecs.DeleteTaskDefinitionsOutput{}
+	for _, arn := range in.TaskDefinitions {
+		td := f.findTaskDefinition(*arn)
+		if td == nil || *td.Status != ecs.TaskDefinitionStatusInactive {
+			out.Failures = append(out.Failures, &ecs.Failure{Arn: arn, Reason: aws.String("The specified task definition is still in ACTIVE status.")})
+			continue
+		}
+		td.Status = aws.String(ecs.TaskDefinitionStatusDeleteInProgress)
+		out.TaskDefinitions = append(out.TaskDefinitions, awsutil.CopyOf(td).(*ecs.TaskDefinition))
+	}
+
+	return out, nil
+}
+
+// fakeNow is rounded up to the millisecond precision of the API, so fake
+// timestamps never sort before the client's own clock.
+func fakeNow() *time.Time {
+	t := time.Now().Truncate(time.Millisecond).Add(time.Millisecond)
+	return &t
+}
@@ -0,0 +1,166 @@
+package ecs
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ecr/ecriface"
+	"github.com/aws/aws-sdk-go/service/ecs"
+
+	cred "github.com/carash/ecs-deploy/credential"
+	"github.com/carash/ecs-deploy/ecr"
+)
+
+// OriginalImageLabel is the docker label recording the image reference a
+// container's digest was resolved from.
+const OriginalImageLabel = "ecs-deploy.original-image"
+
+// ImageWait is how long the plugins wait for images, in seconds. Interval
+// defaults to 10 and Timeout to 300.
+type ImageWait struct {
+	Interval int64
+	Timeout  int64
+}
+
+// registryFor returns the ECR client that resolves the images of region: the
+// injected client when there is one, or a client of that region.
+func (td *TaskDefinition) registryFor(region string) (ecriface.ECRAPI, error) {
+	if td.registry != nil {
+		return td.registry, nil
+	}
+	if td.credential == nil {
+		return nil, fmt.Errorf("Image digests cannot be resolved without an ECR client")
+	}
+
+	if td.registries == nil {
+		td.registries = map[string]ecriface.ECRAPI{}
+	}
+	if reg, ok := td.registries[region]; ok {
+		return reg, nil
+	}
+	reg, err := ecr.NewRegionalClient(*td.credential, region)
+	if err != nil {
+		return nil, err
+	}
+	td.registries[region] = reg
+
+	return reg, nil
+}
+
+// resolveImageDigests replaces the tags of ECR images with the digests they
+// point at, and records the original reference in OriginalImageLabel. The
+// containers are copied rather than modified, since they may belong to the
+// previous revision.
+func (td *TaskDefinition) resolveImageDigests(cds []*ecs.ContainerDefinition) ([]*ecs.ContainerDefinition, error) {
+	containerDefinitions := []*ecs.ContainerDefinition{}
+	for _, cd := range cds {
+		if cd == nil || cd.Image == nil {
+			containerDefinitions = append(containerDefinitions, cd)
+			continue
+		}
+
+		ref, err := ecr.ParseReference(*cd.Image)
+		if err != nil || ref.Digest != "" {
+			td.log().Printf("Image [%s] of Container [%s] is not an ECR tag, it is used as is\n", *cd.Image, aws.StringValue(cd.Name))
+			containerDefinitions = append(containerDefinitions, cd)
+			continue
+		}
+
+		if ref.Tag == "" {
+			ref.Tag = "latest"
+		}
+		image := ref.Image()
+		reg, err := td.registryFor(ref.Region)
+		if err != nil {
+			return nil, err
+		}
+		imgs, err := image.Find(reg)
+		if err != nil {
+			return nil, fmt.Errorf("Image [%s] of Container [%s] cannot be resolved to a digest: %s", *cd.Image, aws.StringValue(cd.Name), err)
+		}
+		if len(imgs) == 0 || imgs[0].ImageDigest == nil {
+			return nil, fmt.Errorf("Image [%s] of Container [%s] was not found", *cd.Image, aws.StringValue(cd.Name))
+		}
+
+		ref.Tag = ""
+		ref.Digest = *imgs[0].ImageDigest
+
+		c := *cd
+		c.Image = aws.String(ref.String())
+		c.DockerLabels = map[string]*string{}
+		for k, v := range cd.DockerLabels {
+			c.DockerLabels[k] = v
+		}
+		c.DockerLabels[OriginalImageLabel] = cd.Image
+		td.log().Printf("Resolved Image [%s] to [%s]\n", *cd.Image, *c.Image)
+
+		containerDefinitions = append(containerDefinitions, &c)
+	}
+
+	return containerDefinitions, nil
+}
+
+// waitForImages waits for the ECR images the Task Definition sets, with the
+// ecr package. Images of the previous revision are not waited for, since they
+// were pushed before it was registered. A dry run only lists the images that
+// would be waited for. Without reg, the ecr package polls
+// the images of each region with a client of that region.
+func (td *TaskDefinition) waitForImages(ctx aws.Context, c cred.Credential, reg ecriface.ECRAPI) error {
+	images := []ecr.Image{}
+	seen := map[string]bool{}
+	add := func(uri *string) {
+		if uri == nil || seen[*uri] {
+			return
+		}
+		seen[*uri] = true
+
+		ref, err := ecr.ParseReference(*uri)
+		if err != nil {
+			td.log().Printf("Image [%s] is not an ECR image, it is not waited for\n", *uri)
+			return
+		}
+		if ref.Tag == "" && ref.Digest == "" {
+			ref.Tag = "latest"
+		}
+		images = append(images, ref.Image())
+	}
+	for _, cd := range td.ContainerDefinitions {
+		if cd != nil {
+			add(cd.Image)
+		}
+	}
+	names := []string{}
+	for name := range td.ContainerImages {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		add(aws.String(td.ContainerImages[name]))
+	}
+	if len(images) == 0 {
+		return nil
+	}
+	if td.DryRun {
+		for _, image := range images {
+			td.log().Printf("Image [%s] would be waited for\n", image.DockerTag())
+		}
+		return nil
+	}
+
+	interval, timeout := td.WaitForImages.Interval, td.WaitForImages.Timeout
+	if interval <= 0 {
+		interval = 10
+	}
+	if timeout <= 0 {
+		timeout = 300
+	}
+
+	p := ecr.ImagePlugin{
+		AWSCredential: c,
+		ECR:           reg,
+		Logger:        td.log(),
+		Images:        images,
+	}
+	return p.WaitForImageWithContext(ctx, interval, timeout)
+}
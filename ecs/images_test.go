@@ -0,0 +1,214 @@
+package ecs
+
+import (
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+	awsecr "github.com/aws/aws-sdk-go/service/ecr"
+	"github.com/aws/aws-sdk-go/service/ecr/ecriface"
+
+	cred "github.com/carash/ecs-deploy/credential"
+)
+
+// fakeECR maps repository:tag to a digest.
+type fakeECR struct {
+	ecriface.ECRAPI
+
+	mu      sync.Mutex
+	digests map[string]string
+}
+
+func (f *fakeECR) push(image, digest string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.digests[image] = digest
+}
+
+func (f *fakeECR) DescribeImagesWithContext(ctx aws.Context, in *awsecr.DescribeImagesInput, opts ...request.Option) (*awsecr.DescribeImagesOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	out := &awsecr.DescribeImagesOutput{}
+	for _, id := range in.ImageIds {
+		digest, ok := f.digests[*in.RepositoryName+":"+aws.StringValue(id.ImageTag)]
+		if !ok {
+			return nil, awserr.New(awsecr.ErrCodeImageNotFoundException, "The image requested does not exist.", nil)
+		}
+		out.ImageDetails = append(out.ImageDetails, &awsecr.ImageDetail{ImageDigest: aws.String(digest)})
+	}
+
+	return out, nil
+}
+
+func TestResolveImageDigests(t *testing.T) {
+	const registry = "123456789012.dkr.ecr.us-east-1.amazonaws.com"
+	reg := &fakeECR{digests: map[string]string{
+		"web:main":       "sha256:aaa",
+		"web:latest":     "sha256:bbb",
+		"team/api:1.2.3": "sha256:ccc",
+	}}
+
+	tests := []struct {
+		name  string
+		image string
+		want  string
+		label string
+		err   string
+	}{
+		{name: "tag", image: registry + "/web:main", want: registry + "/web@sha256:aaa", label: registry + "/web:main"},
+		{name: "implicit latest", image: registry + "/web", want: registry + "/web@sha256:bbb", label: registry + "/web"},
+		{name: "namespaced repository", image: registry + "/team/api:1.2.3", want: registry + "/team/api@sha256:ccc", label: registry + "/team/api:1.2.3"},
+		{name: "not ECR", image: "nginx:1.19", want: "nginx:1.19"},
+		{name: "already a digest", image: registry + "/web@sha256:aaa", want: registry + "/web@sha256:aaa"},
+		{name: "missing tag", image: registry + "/web:gone", err: "cannot be resolved to a digest"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := newFakeECS()
+			td := TaskDefinition{
+				logged:              logged{logger: discard},
+				registry:            reg,
+				Family:              "web",
+				ResolveImageDigests: true,
+				ContainerDefinitions: []*ContainerDefinition{
+					{Name: "web", Image: aws.String(tt.image), DockerLabels: aws.StringMap(map[string]string{"team": "core"})},
+				},
+			}
+
+			registered, err := td.Register(f)
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("Register() = %v, want %q", err, tt.err)
+				}
+				if len(f.taskDefinitions["web"]) != 0 {
+					t.Error("Register() registered a revision")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			cd := registered.ContainerDefinitions[0]
+			if image := aws.StringValue(cd.Image); image != tt.want {
+				t.Errorf("Image = %s, want %s", image, tt.want)
+			}
+			if label := aws.StringValue(cd.DockerLabels[OriginalImageLabel]); label != tt.label {
+				t.Errorf("%s label = %q, want %q", OriginalImageLabel, label, tt.label)
+			}
+			if team := aws.StringValue(cd.DockerLabels["team"]); team != "core" {
+				t.Errorf("team label = %q, want core", team)
+			}
+		})
+	}
+}
+
+func TestRegistryFor(t *testing.T) {
+	td := &TaskDefinition{credential: &cred.Credential{
+		AWSAccessKeyID:     "AKID",
+		AWSSecretAccessKey: "SECRET",
+		AWSRegion:          "eu-west-1",
+		AWSECREndpoint:     "http://localhost:4566",
+	}}
+
+	tests := []struct {
+		region   string
+		endpoint string
+	}{
+		{region: "eu-west-1", endpoint: "http://localhost:4566"},
+		{region: "us-east-1", endpoint: "https://api.ecr.us-east-1.amazonaws.com"},
+		{region: "cn-north-1", endpoint: "https://api.ecr.cn-north-1.amazonaws.com.cn"},
+	}
+
+	for _, tt := range tests {
+		reg, err := td.registryFor(tt.region)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if endpoint := reg.(*awsecr.ECR).Endpoint; endpoint != tt.endpoint {
+			t.Errorf("registryFor(%q) endpoint = %s, want %s", tt.region, endpoint, tt.endpoint)
+		}
+		if again, _ := td.registryFor(tt.region); again != reg {
+			t.Errorf("registryFor(%q) created a second client", tt.region)
+		}
+	}
+}
+
+func TestUpdateServiceWaitForImages(t *testing.T) {
+	const registry = "123456789012.dkr.ecr.us-east-1.amazonaws.com"
+
+	tests := []struct {
+		name    string
+		timeout int64
+		dryRun  bool
+		err     string
+	}{
+		{name: "pushed while waiting", timeout: 3},
+		{name: "never pushed", timeout: 1, err: "Timed out after 1s while waiting for Images [" + registry + "/api:v2]"},
+		{name: "dry run", timeout: 1, dryRun: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			reg := &fakeECR{digests: map[string]string{"web:v2": "sha256:aaa"}}
+			if tt.err == "" && !tt.dryRun {
+				time.AfterFunc(300*time.Millisecond, func() { reg.push("api:v2", "sha256:bbb") })
+			}
+
+			f := newFakeECS()
+			f.addService("web", "web:1", 1)
+			p := &ServicePlugin{
+				ECS:          f,
+				ECR:          reg,
+				Logger:       discard,
+				WaitStrategy: WaitSteadyState,
+				PollInterval: 10 * time.Millisecond,
+				Service: Service{
+					Cluster: aws.String("default"),
+					Service: "web",
+					DryRun:  tt.dryRun,
+					TaskDefinition: &TaskDefinition{
+						Family:        "web",
+						WaitForImages: &ImageWait{Interval: 1, Timeout: tt.timeout},
+						ContainerDefinitions: []*ContainerDefinition{
+							{Name: "web", Image: aws.String(registry + "/web:v2")},
+							{Name: "proxy", Image: aws.String("nginx:1.19")},
+						},
+						ContainerImages: map[string]string{"api": registry + "/api:v2"},
+						AddContainer:    true,
+					},
+				},
+			}
+
+			err := p.UpdateService(5)
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("UpdateService() = %v, want %q", err, tt.err)
+				}
+				if n := len(f.taskDefinitions["web"]); n != 1 {
+					t.Errorf("%d revisions registered, want none", n-1)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tt.dryRun {
+				if n := len(f.taskDefinitions["web"]); n != 1 {
+					t.Errorf("%d revisions registered in a dry run, want none", n-1)
+				}
+				return
+			}
+			if n := len(f.taskDefinitions["web"]); n != 2 {
+				t.Errorf("%d revisions, want 2", n)
+			}
+		})
+	}
+}
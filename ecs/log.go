@@ -0,0 +1,27 @@
+package ecs
+
+import (
+	"github.com/carash/ecs-deploy/logger"
+)
+
+// logged is embedded by the Service, Task Definition, Task and Prune, which
+// are handed the logger of the plugin running them.
+type logged struct {
+	logger logger.Logger
+}
+
+func (l *logged) log() logger.Logger {
+	return logger.OrDefault(l.logger)
+}
+
+func (p *ServicePlugin) log() logger.Logger {
+	return logger.OrDefault(p.Logger)
+}
+
+func (p *TaskPlugin) log() logger.Logger {
+	return logger.OrDefault(p.Logger)
+}
+
+func (p *PrunePlugin) log() logger.Logger {
+	return logger.OrDefault(p.Logger)
+}
@@ -0,0 +1,57 @@
+package ecs
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/cloudwatchlogs"
+	"github.com/aws/aws-sdk-go/service/ecs"
+)
+
+// printLogs prints the CloudWatch log stream of a container using the
+// awslogs log driver.
+func (p *TaskPlugin) printLogs(container *ecs.ContainerDefinition, task *ecs.Task) error {
+	if container.LogConfiguration == nil || aws.StringValue(container.LogConfiguration.LogDriver) != ecs.LogDriverAwslogs {
+		return nil
+	}
+
+	options := container.LogConfiguration.Options
+	group := aws.StringValue(options["awslogs-group"])
+	prefix := aws.StringValue(options["awslogs-stream-prefix"])
+	if group == "" || prefix == "" {
+		p.log().Printf("Logs of Container [%s] cannot be located without awslogs-group and awslogs-stream-prefix\n\n", aws.StringValue(container.Name))
+		return nil
+	}
+	stream := fmt.Sprintf("%s/%s/%s", prefix, aws.StringValue(container.Name), taskID(task))
+
+	logs := p.Logs
+	if logs == nil {
+		sess, err := p.AWSCredential.NewSession()
+		if err != nil {
+			return err
+		}
+
+		config := aws.NewConfig()
+		if region := aws.StringValue(options["awslogs-region"]); region != "" {
+			config = config.WithRegion(region)
+		}
+		logs = cloudwatchlogs.New(sess, config)
+	}
+
+	p.log().Printf("Logs of Container [%s] from [%s] [%s]:\n", aws.StringValue(container.Name), group, stream)
+	err := logs.GetLogEventsPages(&cloudwatchlogs.GetLogEventsInput{
+		LogGroupName:  &group,
+		LogStreamName: &stream,
+		StartFromHead: aws.Bool(true),
+	}, func(out *cloudwatchlogs.GetLogEventsOutput, last bool) bool {
+		for _, e := range out.Events {
+			ts := time.Unix(0, aws.Int64Value(e.Timestamp)*int64(time.Millisecond)).UTC()
+			p.log().Printf("%s %s\n", ts.Format(time.RFC3339), aws.StringValue(e.Message))
+		}
+		return len(out.Events) > 0
+	})
+	p.log().Printf("\n")
+
+	return err
+}
@@ -2,109 +2,402 @@ package ecs
 
 import (
 	"fmt"
+	"strings"
 	"time"
 
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/cloudwatchlogs/cloudwatchlogsiface"
+	"github.com/aws/aws-sdk-go/service/ecr/ecriface"
 	"github.com/aws/aws-sdk-go/service/ecs"
+	"github.com/aws/aws-sdk-go/service/ecs/ecsiface"
+	"github.com/aws/aws-sdk-go/service/ssm/ssmiface"
 
 	cred "github.com/carash/ecs-deploy/credential"
+	"github.com/carash/ecs-deploy/logger"
 )
 
 type ServicePlugin struct {
 	AWSCredential cred.Credential
-	Service       Service
+	// ECS, ECR and SSM are used instead of clients created from
+	// AWSCredential when set
+	ECS ecsiface.ECSAPI
+	ECR ecriface.ECRAPI
+	SSM ssmiface.SSMAPI
+	// Logger receives the progress messages, which go to stdout by default
+	Logger logger.Logger
+
+	Service           Service
+	RollbackOnFailure bool
+	RollbackOnCancel  bool
+	WaitStrategy      string
+	MaxFailedTasks    int64
+	PollInterval      time.Duration
+	// Prune runs after a successful UpdateService, on the family of the
+	// deployed Task Definition, when set
+	Prune *Prune
+
+	// Result is filled in by UpdateService
+	Result *DeployResult
+}
+
+// RollbackError is returned when a failed deployment was rolled back to the
+// previous Task Definition of the Service.
+type RollbackError struct {
+	Err            error
+	TaskDefinition string
+}
+
+func (e *RollbackError) Error() string {
+	return fmt.Sprintf("%s, rolled back to [%s]", strings.TrimSpace(e.Err.Error()), e.TaskDefinition)
 }
 
 type TaskPlugin struct {
-	AWSCredential  cred.Credential
+	AWSCredential cred.Credential
+	// ECS, ECR, Logs and SSM are used instead of clients created from
+	// AWSCredential when set
+	ECS  ecsiface.ECSAPI
+	ECR  ecriface.ECRAPI
+	Logs cloudwatchlogsiface.CloudWatchLogsAPI
+	SSM  ssmiface.SSMAPI
+	// Logger receives the progress messages, which go to stdout by default
+	Logger logger.Logger
+
 	TaskDefinition TaskDefinition
+	Task           Task
+	PollInterval   time.Duration
+
+	// Result is filled in by RunTask
+	Result *TaskResult
+}
+
+func newECS(c cred.Credential, svc ecsiface.ECSAPI) (ecsiface.ECSAPI, error) {
+	if svc != nil {
+		return svc, nil
+	}
+
+	sess, err := c.NewSession()
+	if err != nil {
+		return nil, err
+	}
+
+	return ecs.New(sess), nil
 }
 
 func (p *ServicePlugin) DeployService() error {
-	svc := ecs.New(p.AWSCredential.NewSession())
-	_, err := p.Service.Deploy(svc)
+	svc, err := newECS(p.AWSCredential, p.ECS)
+	if err != nil {
+		return err
+	}
+
+	// the Service only hands DryRun to its Task Definition once deploying,
+	// after the images are waited for
+	if p.Service.TaskDefinition != nil {
+		p.Service.TaskDefinition.DryRun = p.Service.TaskDefinition.DryRun || p.Service.DryRun
+	}
+	if err := prepare(aws.BackgroundContext(), p.AWSCredential, p.Service.TaskDefinition, p.ECR, p.SSM, p.log()); err != nil {
+		return err
+	}
+
+	p.Service.logger = p.log()
+	_, err = p.Service.Deploy(svc)
 	return err
 }
 
 func (p *ServicePlugin) UpdateService(timeout int64) error {
-	svc := ecs.New(p.AWSCredential.NewSession())
+	return p.UpdateServiceWithContext(aws.BackgroundContext(), timeout)
+}
+
+// UpdateServiceWithContext is the same as UpdateService, but stops waiting
+// for the deployment once ctx is cancelled. With RollbackOnCancel, the
+// rollback to the previous Task Definition is then started but not waited for.
+func (p *ServicePlugin) UpdateServiceWithContext(ctx aws.Context, timeout int64) error {
+	p.Result = &DeployResult{
+		Cluster:   aws.StringValue(p.Service.Cluster),
+		Service:   p.Service.Service,
+		StartedAt: time.Now(),
+	}
+
+	err := p.updateService(ctx, timeout)
+	p.Result.finish(ctx, err)
+	return err
+}
+
+func (p *ServicePlugin) updateService(ctx aws.Context, timeout int64) error {
+	if _, err := p.waitCheck(); err != nil {
+		return err
+	}
+
+	svc, err := newECS(p.AWSCredential, p.ECS)
+	if err != nil {
+		return err
+	}
+
+	previous, err := findService(ctx, svc, p.Service.Cluster, p.Service.Service)
+	if err != nil {
+		return err
+	}
+	if previous != nil {
+		p.Result.PreviousTaskDefinitionArn = aws.StringValue(previous.TaskDefinition)
+	}
+
+	// the Service only hands DryRun to its Task Definition once deploying,
+	// after the images are waited for
+	if p.Service.TaskDefinition != nil {
+		p.Service.TaskDefinition.DryRun = p.Service.TaskDefinition.DryRun || p.Service.DryRun
+	}
+	if err := prepare(ctx, p.AWSCredential, p.Service.TaskDefinition, p.ECR, p.SSM, p.log()); err != nil {
+		return err
+	}
+
+	since := time.Now()
+	p.Service.logger = p.log()
 	service, err := p.Service.Update(svc)
 	if err != nil {
 		return err
 	}
+	if p.Service.DryRun {
+		p.Result.Status = StatusPlanned
+		p.prune(ctx, svc, aws.StringValue(service.TaskDefinition))
+		return nil
+	}
 
-	start := time.Now()
-	check := make(chan error)
-	td, _ := parseFamilyRevision(*service.TaskDefinition)
-
-	go func() {
-		for {
-			go func() {
-				taskout, err := svc.ListTasks(&ecs.ListTasksInput{
-					Cluster:     p.Service.Cluster,
-					ServiceName: &p.Service.Service,
-				})
-				if err != nil {
-					check <- err
-				}
-				if len(taskout.TaskArns) == 0 {
-					return
-				}
-
-				detout, err := svc.DescribeTasks(&ecs.DescribeTasksInput{
-					Cluster: p.Service.Cluster,
-					Tasks:   taskout.TaskArns,
-				})
-				if err != nil {
-					check <- err
-				}
-
-				healthy := int64(0)
-				for _, t := range detout.Tasks {
-					taskDefinition, _ := parseFamilyRevision(*t.TaskDefinitionArn)
-					fmt.Printf("Status of [%s] -> %s\n", taskDefinition, *t.HealthStatus)
-
-					if *t.TaskDefinitionArn == *service.TaskDefinition && *t.HealthStatus == "HEALTHY" {
-						healthy += 1
-					}
-				}
-				fmt.Println()
-
-				if int64(len(taskout.TaskArns)) != *service.DesiredCount {
-					return
-				}
-				if healthy == *service.DesiredCount {
-					fmt.Printf("Task [%s] is HEALTHY after %d seconds\n\n", td, int64(time.Now().Sub(start).Seconds()))
-					check <- nil
-					return
-				}
-			}()
-
-			time.Sleep(10 * time.Second)
-			fmt.Printf("Waiting for Task [%s] to be HEALTHY, %ds...\n", td, int64(time.Now().Sub(start).Seconds()))
+	p.Result.TaskDefinitionArn = aws.StringValue(service.TaskDefinition)
+	for _, d := range service.Deployments {
+		if aws.StringValue(d.Status) == "PRIMARY" {
+			p.Result.DeploymentId = aws.StringValue(d.Id)
 		}
-	}()
+	}
 
-	select {
-	case err := <-check:
-		if err != nil {
+	if err := p.waitForService(ctx, svc, service, timeout, since); err != nil {
+		if previous == nil {
 			return err
 		}
-	case <-time.After(time.Duration(timeout) * time.Second):
-		return fmt.Errorf("Timed out after %ds while wating for Task [%s] to deploy\n\n", int64(time.Now().Sub(start).Seconds()), td)
+		if ctx.Err() != nil && p.RollbackOnCancel || ctx.Err() == nil && p.RollbackOnFailure {
+			return p.rollback(ctx, svc, previous, timeout, err)
+		}
+		return err
 	}
 
+	p.prune(ctx, svc, *service.TaskDefinition)
 	return nil
+}
+
+// prune removes old revisions once the deployment succeeded, or lists them in
+// a dry run. Its failures are only logged and recorded in the result, since
+// the deployment itself went through.
+func (p *ServicePlugin) prune(ctx aws.Context, svc ecsiface.ECSAPI, taskDefinition string) {
+	if p.Prune == nil {
+		return
+	}
+
+	pr := *p.Prune
+	pr.logger = p.log()
+	pr.DryRun = pr.DryRun || p.Service.DryRun
+	if pr.Family == "" {
+		family, err := parseFamily(taskDefinition)
+		if err != nil {
+			// a Service created in a dry run has no revision yet
+			return
+		}
+		pr.Family = family
+	}
+	if pr.Cluster == nil {
+		pr.Cluster = p.Service.Cluster
+	}
+
+	result, err := pr.Run(ctx, svc)
+	if err != nil {
+		p.log().Printf("Task Definitions of [%s] cannot be pruned: %s\n", pr.Family, err)
+		if result == nil {
+			result = &PruneResult{Family: pr.Family, Kept: []string{}, Deregistered: []string{}}
+		}
+		result.Error = strings.TrimSpace(err.Error())
+	}
+	p.Result.Prune = result
+}
+
+// prepare hands td the ECR client it resolves image digests with, waits for
+// its images, and reads its environment files and Parameter Store secrets.
+func prepare(ctx aws.Context, c cred.Credential, td *TaskDefinition, reg ecriface.ECRAPI, ssm ssmiface.SSMAPI, log logger.Logger) error {
+	if td == nil {
+		return nil
+	}
+
+	td.logger = log
+	if td.ResolveImageDigests {
+		td.registry = reg
+		td.credential = &c
+	}
+	if td.WaitForImages != nil {
+		if err := td.waitForImages(ctx, c, reg); err != nil {
+			return err
+		}
+	}
+
+	return td.loadVariables(ctx, c, ssm)
+}
+
+// rollback waits for the previous Task Definition to deploy again, unless ctx
+// was cancelled, in which case the rollback is only started.
+func (p *ServicePlugin) rollback(ctx aws.Context, svc ecsiface.ECSAPI, previous *ecs.Service, timeout int64, cause error) error {
+	td, _ := parseFamilyRevision(*previous.TaskDefinition)
+	p.log().Printf("%s\nRolling back Service [%s] to [%s]...\n", strings.TrimSpace(cause.Error()), p.Service.Service, td)
+
+	since := time.Now()
+	snew, err := svc.UpdateService(&ecs.UpdateServiceInput{
+		Cluster:        p.Service.Cluster,
+		Service:        &p.Service.Service,
+		TaskDefinition: previous.TaskDefinition,
+		DesiredCount:   previous.DesiredCount,
+	})
+	if err != nil {
+		return fmt.Errorf("%s, and rollback to [%s] failed: %s", strings.TrimSpace(cause.Error()), td, err)
+	}
+	if ctx.Err() != nil {
+		p.log().Printf("Started rollback to [%s], not waiting for it to deploy\n", td)
+		return &RollbackError{Err: cause, TaskDefinition: td}
+	}
+
+	if err := p.waitForService(ctx, svc, snew.Service, timeout, since); err != nil {
+		return fmt.Errorf("%s, and rollback to [%s] failed: %s", strings.TrimSpace(cause.Error()), td, strings.TrimSpace(err.Error()))
+	}
+
+	return &RollbackError{Err: cause, TaskDefinition: td}
+}
+
+type PrunePlugin struct {
+	AWSCredential cred.Credential
+	// ECS is used instead of a client created from AWSCredential when set
+	ECS ecsiface.ECSAPI
+	// Logger receives the progress messages, which go to stdout by default
+	Logger logger.Logger
+
+	Prune Prune
+
+	// Result is filled in by PruneTaskDefinitions
+	Result *PruneResult
+}
+
+func (p *PrunePlugin) PruneTaskDefinitions() error {
+	return p.PruneTaskDefinitionsWithContext(aws.BackgroundContext())
+}
+
+// PruneTaskDefinitionsWithContext is the same as PruneTaskDefinitions, but
+// stops once ctx is cancelled.
+func (p *PrunePlugin) PruneTaskDefinitionsWithContext(ctx aws.Context) error {
+	startedAt := time.Now()
+
+	err := p.pruneTaskDefinitions(ctx)
+	if p.Result == nil {
+		p.Result = &PruneResult{Family: p.Prune.Family}
+	}
+	p.Result.finish(ctx, err, startedAt)
+	return err
+}
+
+func (p *PrunePlugin) pruneTaskDefinitions(ctx aws.Context) error {
+	svc, err := newECS(p.AWSCredential, p.ECS)
+	if err != nil {
+		return err
+	}
 
+	p.Prune.logger = p.log()
+	p.Result, err = p.Prune.Run(ctx, svc)
+	if err == nil && p.Prune.DryRun {
+		p.Result.Status = StatusPlanned
+	}
+	return err
 }
 
 func (p *TaskPlugin) RegisterTask() error {
-	svc := ecs.New(p.AWSCredential.NewSession())
-	_, err := p.TaskDefinition.Register(svc)
+	svc, err := newECS(p.AWSCredential, p.ECS)
+	if err != nil {
+		return err
+	}
+
+	if err := prepare(aws.BackgroundContext(), p.AWSCredential, &p.TaskDefinition, p.ECR, p.SSM, p.log()); err != nil {
+		return err
+	}
+
+	p.TaskDefinition.logger = p.log()
+	_, err = p.TaskDefinition.Register(svc)
 	return err
 }
 
 func (p *TaskPlugin) UpdateTask() error {
-	svc := ecs.New(p.AWSCredential.NewSession())
-	_, err := p.TaskDefinition.Update(svc)
+	svc, err := newECS(p.AWSCredential, p.ECS)
+	if err != nil {
+		return err
+	}
+
+	if err := prepare(aws.BackgroundContext(), p.AWSCredential, &p.TaskDefinition, p.ECR, p.SSM, p.log()); err != nil {
+		return err
+	}
+
+	p.TaskDefinition.logger = p.log()
+	_, err = p.TaskDefinition.Update(svc)
 	return err
 }
+
+// RunTask registers the Task Definition, runs it once and waits for it to
+// stop. It returns the exit code of the Task's container.
+func (p *TaskPlugin) RunTask(timeout int64) (int64, error) {
+	return p.RunTaskWithContext(aws.BackgroundContext(), timeout)
+}
+
+// RunTaskWithContext is the same as RunTask, but stops waiting for the Task
+// once ctx is cancelled. The Task itself keeps running.
+func (p *TaskPlugin) RunTaskWithContext(ctx aws.Context, timeout int64) (int64, error) {
+	p.Result = &TaskResult{
+		Cluster:   aws.StringValue(p.Task.Cluster),
+		StartedAt: time.Now(),
+	}
+
+	code, err := p.runTask(ctx, timeout)
+	p.Result.finish(ctx, err)
+	return code, err
+}
+
+func (p *TaskPlugin) runTask(ctx aws.Context, timeout int64) (int64, error) {
+	svc, err := newECS(p.AWSCredential, p.ECS)
+	if err != nil {
+		return 0, err
+	}
+
+	if err := prepare(ctx, p.AWSCredential, &p.TaskDefinition, p.ECR, p.SSM, p.log()); err != nil {
+		return 0, err
+	}
+
+	p.Task.logger = p.log()
+	task, err := p.Task.Run(svc, &p.TaskDefinition)
+	if err != nil {
+		return 0, err
+	}
+	if task == nil {
+		p.Result.Status = StatusPlanned
+		return 0, nil
+	}
+	p.Result.TaskDefinitionArn = aws.StringValue(task.TaskDefinitionArn)
+	p.Result.TaskArn = aws.StringValue(task.TaskArn)
+
+	stopped, err := p.waitForTask(ctx, svc, task, timeout)
+	if err != nil {
+		return 0, err
+	}
+
+	container, err := p.Task.container()
+	if err != nil {
+		return 0, err
+	}
+	if err := p.printLogs(container, stopped); err != nil {
+		p.log().Printf("Logs of Container [%s] cannot be read: %s\n\n", *container.Name, err)
+	}
+
+	code, err := p.Task.exitCode(stopped)
+	if err != nil {
+		return 0, err
+	}
+	p.Result.ExitCode = &code
+
+	return code, nil
+}
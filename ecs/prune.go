@@ -0,0 +1,226 @@
+package ecs
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ecs"
+	"github.com/aws/aws-sdk-go/service/ecs/ecsiface"
+)
+
+// Prune deregisters the revisions of a Task Definition family that are
+// neither among the Keep most recent nor used by a Service of the cluster.
+// With DeleteInactive, the INACTIVE revisions of the family are then deleted.
+type Prune struct {
+	logged
+
+	DeleteInactive bool
+	DryRun         bool
+
+	Family string
+	// Service is used to find the Family when it is empty
+	Service *string
+	Cluster *string
+	Keep    int64
+}
+
+func (pr *Prune) isValid() error {
+	if pr.Family == "" && pr.Service == nil {
+		return fmt.Errorf("Prune must have a Task Definition family or a Service")
+	}
+	if pr.Family != "" {
+		if _, err := parseFamily(pr.Family); err != nil {
+			return fmt.Errorf("Task Definition Family cannot be parsed")
+		}
+	}
+	if pr.Keep < 1 {
+		return fmt.Errorf("Prune must keep at least 1 revision")
+	}
+
+	return nil
+}
+
+// Run prunes the family. In a dry run, the result lists what would be
+// removed without removing it.
+func (pr *Prune) Run(ctx aws.Context, svc ecsiface.ECSAPI) (*PruneResult, error) {
+	if err := pr.isValid(); err != nil {
+		return nil, err
+	}
+
+	family := pr.Family
+	if family == "" {
+		srv, err := describeService(ctx, svc, pr.Cluster, *pr.Service)
+		if err != nil {
+			return nil, err
+		}
+		family = *srv.TaskDefinition
+	}
+	family, _ = parseFamily(family)
+	result := &PruneResult{Family: family, Kept: []string{}, Deregistered: []string{}}
+
+	inUse, err := pr.inUse(ctx, svc)
+	if err != nil {
+		return result, err
+	}
+
+	active, err := listRevisions(ctx, svc, family, ecs.TaskDefinitionStatusActive)
+	if err != nil {
+		return result, err
+	}
+
+	pr.log().Printf("Pruning Task Definition [%s], keeping the %d most recent revisions and those in use...\n", family, pr.Keep)
+	deregister := []string{}
+	for i, arn := range active {
+		revision, _ := parseFamilyRevision(arn)
+		if int64(i) < pr.Keep || inUse[arn] {
+			result.Kept = append(result.Kept, revision)
+		} else {
+			deregister = append(deregister, arn)
+		}
+	}
+
+	for _, arn := range deregister {
+		revision, _ := parseFamilyRevision(arn)
+		if pr.DryRun {
+			pr.log().Printf("Would deregister [%s]\n", revision)
+		} else {
+			pr.log().Printf("Deregistering [%s]\n", revision)
+			if _, err := svc.DeregisterTaskDefinitionWithContext(ctx, &ecs.DeregisterTaskDefinitionInput{TaskDefinition: aws.String(arn)}); err != nil {
+				return result, fmt.Errorf("Task Definition [%s] cannot be deregistered: %s", revision, err)
+			}
+		}
+		result.Deregistered = append(result.Deregistered, revision)
+	}
+
+	if pr.DeleteInactive {
+		inactive, err := listRevisions(ctx, svc, family, ecs.TaskDefinitionStatusInactive)
+		if err != nil {
+			return result, err
+		}
+
+		listed := map[string]bool{}
+		for _, arn := range inactive {
+			listed[arn] = true
+		}
+		// the listing may lag behind the revisions just deregistered
+		for _, arn := range deregister {
+			if !listed[arn] {
+				inactive = append(inactive, arn)
+			}
+		}
+
+		result.Deleted = []string{}
+		if err := pr.delete(ctx, svc, inactive, result); err != nil {
+			return result, err
+		}
+	}
+
+	pr.log().Printf("Kept %d and deregistered %d revisions of [%s]\n\n", len(result.Kept), len(result.Deregistered), family)
+	return result, nil
+}
+
+// inUse collects the Task Definitions of the Services of the cluster and of
+// their deployments.
+func (pr *Prune) inUse(ctx aws.Context, svc ecsiface.ECSAPI) (map[string]bool, error) {
+	arns := []*string{}
+	err := svc.ListServicesPagesWithContext(ctx, &ecs.ListServicesInput{Cluster: pr.Cluster}, func(out *ecs.ListServicesOutput, last bool) bool {
+		arns = append(arns, out.ServiceArns...)
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	inUse := map[string]bool{}
+	for i := 0; i < len(arns); i += 10 {
+		end := i + 10
+		if end > len(arns) {
+			end = len(arns)
+		}
+
+		out, err := svc.DescribeServicesWithContext(ctx, &ecs.DescribeServicesInput{
+			Cluster:  pr.Cluster,
+			Services: arns[i:end],
+		})
+		if err != nil {
+			return nil, err
+		}
+		for _, srv := range out.Services {
+			inUse[aws.StringValue(srv.TaskDefinition)] = true
+			for _, d := range srv.Deployments {
+				inUse[aws.StringValue(d.TaskDefinition)] = true
+			}
+		}
+	}
+
+	return inUse, nil
+}
+
+// delete removes INACTIVE revisions, at most 10 per call.
+func (pr *Prune) delete(ctx aws.Context, svc ecsiface.ECSAPI, arns []string, result *PruneResult) error {
+	for i := 0; i < len(arns); i += 10 {
+		end := i + 10
+		if end > len(arns) {
+			end = len(arns)
+		}
+
+		for _, arn := range arns[i:end] {
+			revision, _ := parseFamilyRevision(arn)
+			if pr.DryRun {
+				pr.log().Printf("Would delete [%s]\n", revision)
+				result.Deleted = append(result.Deleted, revision)
+			} else {
+				pr.log().Printf("Deleting [%s]\n", revision)
+			}
+		}
+		if pr.DryRun {
+			continue
+		}
+
+		out, err := svc.DeleteTaskDefinitionsWithContext(ctx, &ecs.DeleteTaskDefinitionsInput{
+			TaskDefinitions: aws.StringSlice(arns[i:end]),
+		})
+		if err != nil {
+			return fmt.Errorf("Task Definitions cannot be deleted: %s", err)
+		}
+		for _, td := range out.TaskDefinitions {
+			revision, _ := parseFamilyRevision(aws.StringValue(td.TaskDefinitionArn))
+			result.Deleted = append(result.Deleted, revision)
+		}
+		if len(out.Failures) > 0 {
+			failures := []string{}
+			for _, f := range out.Failures {
+				revision, _ := parseFamilyRevision(aws.StringValue(f.Arn))
+				failures = append(failures, fmt.Sprintf("[%s] %s", revision, aws.StringValue(f.Reason)))
+			}
+			return fmt.Errorf("Task Definitions cannot be deleted: %s", strings.Join(failures, ", "))
+		}
+	}
+
+	return nil
+}
+
+// listRevisions returns the ARNs of the family's revisions, most recent
+// first. The family prefix filter also matches longer families, which are
+// left out.
+func listRevisions(ctx aws.Context, svc ecsiface.ECSAPI, family, status string) ([]string, error) {
+	arns := []string{}
+	err := svc.ListTaskDefinitionsPagesWithContext(ctx, &ecs.ListTaskDefinitionsInput{
+		FamilyPrefix: aws.String(family),
+		Status:       aws.String(status),
+		Sort:         aws.String(ecs.SortOrderDesc),
+	}, func(out *ecs.ListTaskDefinitionsOutput, last bool) bool {
+		for _, arn := range out.TaskDefinitionArns {
+			if f, err := parseFamily(aws.StringValue(arn)); err == nil && f == family {
+				arns = append(arns, *arn)
+			}
+		}
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return arns, nil
+}
@@ -0,0 +1,176 @@
+package ecs
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ecs"
+)
+
+func TestPrune(t *testing.T) {
+	tests := []struct {
+		name         string
+		prune        Prune
+		kept         []string
+		deregistered []string
+		deleted      []string
+		statuses     []string
+	}{
+		{
+			name:         "keeps recent and in use revisions",
+			prune:        Prune{Family: "web", Keep: 2},
+			kept:         []string{"web:5", "web:4", "web:1"},
+			deregistered: []string{"web:3", "web:2"},
+			statuses:     []string{"ACTIVE", "INACTIVE", "INACTIVE", "ACTIVE", "ACTIVE"},
+		},
+		{
+			name:         "dry run",
+			prune:        Prune{Family: "web", Keep: 2, DeleteInactive: true, DryRun: true},
+			kept:         []string{"web:5", "web:4", "web:1"},
+			deregistered: []string{"web:3", "web:2"},
+			deleted:      []string{"web:3", "web:2"},
+			statuses:     []string{"ACTIVE", "ACTIVE", "ACTIVE", "ACTIVE", "ACTIVE"},
+		},
+		{
+			name:         "deletes inactive revisions",
+			prune:        Prune{Service: aws.String("web"), Keep: 3, DeleteInactive: true},
+			kept:         []string{"web:5", "web:4", "web:3", "web:1"},
+			deregistered: []string{"web:2"},
+			deleted:      []string{"web:2"},
+			statuses:     []string{"ACTIVE", "DELETE_IN_PROGRESS", "ACTIVE", "ACTIVE", "ACTIVE"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := newFakeECS()
+			f.addService("web", "web:1", 1)
+			for i := 2; i <= 5; i++ {
+				f.register(&ecs.RegisterTaskDefinitionInput{Family: aws.String("web")})
+			}
+			f.register(&ecs.RegisterTaskDefinitionInput{Family: aws.String("web-worker")})
+
+			p := &PrunePlugin{ECS: f, Logger: discard, Prune: tt.prune}
+			p.Prune.Cluster = aws.String("default")
+			if err := p.PruneTaskDefinitions(); err != nil {
+				t.Fatal(err)
+			}
+
+			if !reflect.DeepEqual(p.Result.Kept, tt.kept) {
+				t.Errorf("Kept = %v, want %v", p.Result.Kept, tt.kept)
+			}
+			if !reflect.DeepEqual(p.Result.Deregistered, tt.deregistered) {
+				t.Errorf("Deregistered = %v, want %v", p.Result.Deregistered, tt.deregistered)
+			}
+			if !reflect.DeepEqual(p.Result.Deleted, tt.deleted) {
+				t.Errorf("Deleted = %v, want %v", p.Result.Deleted, tt.deleted)
+			}
+
+			statuses := []string{}
+			for _, td := range f.taskDefinitions["web"] {
+				statuses = append(statuses, *td.Status)
+			}
+			if !reflect.DeepEqual(statuses, tt.statuses) {
+				t.Errorf("statuses = %v, want %v", statuses, tt.statuses)
+			}
+			if status := *f.taskDefinitions["web-worker"][0].Status; status != "ACTIVE" {
+				t.Errorf("web-worker:1 is %s, want ACTIVE", status)
+			}
+		})
+	}
+}
+
+func TestPrunePartitions(t *testing.T) {
+	tests := []struct {
+		partition string
+		region    string
+	}{
+		{partition: "aws-cn", region: "cn-north-1"},
+		{partition: "aws-us-gov", region: "us-gov-west-1"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.partition, func(t *testing.T) {
+			f := newFakeECS()
+			f.partition = tt.partition
+			f.region = tt.region
+			for i := 1; i <= 3; i++ {
+				f.register(&ecs.RegisterTaskDefinitionInput{Family: aws.String("web")})
+			}
+
+			p := &PrunePlugin{ECS: f, Logger: discard, Prune: Prune{Family: "web", Keep: 1}}
+			if err := p.PruneTaskDefinitions(); err != nil {
+				t.Fatal(err)
+			}
+
+			if want := []string{"web:3"}; !reflect.DeepEqual(p.Result.Kept, want) {
+				t.Errorf("Kept = %v, want %v", p.Result.Kept, want)
+			}
+			if want := []string{"web:2", "web:1"}; !reflect.DeepEqual(p.Result.Deregistered, want) {
+				t.Errorf("Deregistered = %v, want %v", p.Result.Deregistered, want)
+			}
+		})
+	}
+}
+
+func TestUpdateServicePrune(t *testing.T) {
+	f := newFakeECS()
+	f.addService("web", "web:1", 2)
+
+	p := &ServicePlugin{
+		ECS:          f,
+		Logger:       discard,
+		WaitStrategy: WaitSteadyState,
+		PollInterval: 10 * time.Millisecond,
+		Prune:        &Prune{Keep: 1},
+		Service: Service{
+			Cluster: aws.String("default"),
+			Service: "web",
+			TaskDefinition: &TaskDefinition{Family: "web", ContainerDefinitions: []*ContainerDefinition{
+				{Name: "web", Image: aws.String("web:2")},
+			}},
+		},
+	}
+
+	if err := p.UpdateService(5); err != nil {
+		t.Fatal(err)
+	}
+	if p.Result.Prune == nil || !reflect.DeepEqual(p.Result.Prune.Deregistered, []string{"web:1"}) {
+		t.Fatalf("Result.Prune = %+v, want web:1 deregistered", p.Result.Prune)
+	}
+	if status := *f.taskDefinitions["web"][0].Status; status != "INACTIVE" {
+		t.Errorf("web:1 is %s, want INACTIVE", status)
+	}
+}
+
+func TestUpdateServicePruneFailure(t *testing.T) {
+	f := newFakeECS()
+	f.addService("web", "web:1", 2)
+
+	p := &ServicePlugin{
+		ECS:          f,
+		Logger:       discard,
+		WaitStrategy: WaitSteadyState,
+		PollInterval: 10 * time.Millisecond,
+		Prune:        &Prune{Keep: 0},
+		Service: Service{
+			Cluster: aws.String("default"),
+			Service: "web",
+			TaskDefinition: &TaskDefinition{Family: "web", ContainerDefinitions: []*ContainerDefinition{
+				{Name: "web", Image: aws.String("web:2")},
+			}},
+		},
+	}
+
+	if err := p.UpdateService(5); err != nil {
+		t.Fatal(err)
+	}
+	if p.Result.Status != StatusSucceeded {
+		t.Errorf("Status = %s, want %s", p.Result.Status, StatusSucceeded)
+	}
+	if p.Result.Prune == nil || p.Result.Prune.Error != "Prune must keep at least 1 revision" {
+		t.Fatalf("Result.Prune = %+v, want the prune error", p.Result.Prune)
+	}
+}
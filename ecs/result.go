@@ -0,0 +1,97 @@
+package ecs
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+)
+
+// Final statuses of a DeployResult or TaskResult.
+const (
+	StatusSucceeded  = "succeeded"
+	StatusFailed     = "failed"
+	StatusRolledBack = "rolled-back"
+	StatusCancelled  = "cancelled"
+	StatusPlanned    = "planned"
+)
+
+// DeployResult describes a deployment for the pipeline steps that follow it.
+type DeployResult struct {
+	Cluster                   string       `json:"cluster,omitempty"`
+	Service                   string       `json:"service"`
+	PreviousTaskDefinitionArn string       `json:"previousTaskDefinitionArn,omitempty"`
+	TaskDefinitionArn         string       `json:"taskDefinitionArn,omitempty"`
+	DeploymentId              string       `json:"deploymentId,omitempty"`
+	Prune                     *PruneResult `json:"prune,omitempty"`
+	Status                    string       `json:"status"`
+	Error                     string       `json:"error,omitempty"`
+	StartedAt                 time.Time    `json:"startedAt"`
+	DurationSeconds           float64      `json:"durationSeconds"`
+}
+
+func (r *DeployResult) finish(ctx aws.Context, err error) {
+	r.Status, r.Error = status(ctx, err, r.Status)
+	r.DurationSeconds = time.Now().Sub(r.StartedAt).Seconds()
+}
+
+// TaskResult describes a one-off run of a Task.
+type TaskResult struct {
+	Cluster           string    `json:"cluster,omitempty"`
+	TaskDefinitionArn string    `json:"taskDefinitionArn,omitempty"`
+	TaskArn           string    `json:"taskArn,omitempty"`
+	ExitCode          *int64    `json:"exitCode,omitempty"`
+	Status            string    `json:"status"`
+	Error             string    `json:"error,omitempty"`
+	StartedAt         time.Time `json:"startedAt"`
+	DurationSeconds   float64   `json:"durationSeconds"`
+}
+
+func (r *TaskResult) finish(ctx aws.Context, err error) {
+	r.Status, r.Error = status(ctx, err, r.Status)
+	if r.Status == StatusSucceeded && aws.Int64Value(r.ExitCode) != 0 {
+		r.Status = StatusFailed
+	}
+	r.DurationSeconds = time.Now().Sub(r.StartedAt).Seconds()
+}
+
+// PruneResult lists the revisions of a family a prune kept and removed, as
+// family:revision.
+type PruneResult struct {
+	Family       string   `json:"family"`
+	Kept         []string `json:"kept"`
+	Deregistered []string `json:"deregistered"`
+	Deleted      []string `json:"deleted,omitempty"`
+	// Error is set when the prune failed, even after a successful deploy
+	Error string `json:"error,omitempty"`
+	// Status and the fields after it are only set by PrunePlugin
+	Status          string     `json:"status,omitempty"`
+	StartedAt       *time.Time `json:"startedAt,omitempty"`
+	DurationSeconds float64    `json:"durationSeconds,omitempty"`
+}
+
+func (r *PruneResult) finish(ctx aws.Context, err error, startedAt time.Time) {
+	r.Status, r.Error = status(ctx, err, r.Status)
+	r.StartedAt = &startedAt
+	r.DurationSeconds = time.Now().Sub(startedAt).Seconds()
+}
+
+func status(ctx aws.Context, err error, current string) (string, string) {
+	if err == nil {
+		if current != "" {
+			return current, ""
+		}
+		return StatusSucceeded, ""
+	}
+
+	msg := strings.TrimSpace(err.Error())
+	if ctx.Err() == context.Canceled {
+		return StatusCancelled, msg
+	}
+	if _, ok := err.(*RollbackError); ok {
+		return StatusRolledBack, msg
+	}
+
+	return StatusFailed, msg
+}
@@ -3,12 +3,19 @@ package ecs
 import (
 	"fmt"
 
+	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/service/ecs"
+	"github.com/aws/aws-sdk-go/service/ecs/ecsiface"
 )
 
 type Service struct {
+	logged
+
+	DryRun          bool
+	CreateIfMissing bool
+
 	Cluster *string
-	Service string
+	Service string `json:"serviceName"`
 
 	PlatformVersion      *string
 	NetworkConfiguration *ecs.NetworkConfiguration
@@ -19,6 +26,20 @@ type Service struct {
 	DeploymentConfiguration       *ecs.DeploymentConfiguration
 	DesiredCount                  *int64
 	HealthCheckGracePeriodSeconds *int64
+
+	// only used when the Service is created
+	LaunchType               *string
+	CapacityProviderStrategy []*ecs.CapacityProviderStrategyItem
+	SchedulingStrategy       *string
+	DeploymentController     *ecs.DeploymentController
+	LoadBalancers            []*ecs.LoadBalancer
+	ServiceRegistries        []*ecs.ServiceRegistry
+	PlacementConstraints     []*ecs.PlacementConstraint
+	PlacementStrategy        []*ecs.PlacementStrategy
+	Role                     *string
+	EnableECSManagedTags     *bool
+	PropagateTags            *string
+	Tags                     []*ecs.Tag
 }
 
 func (s *Service) isValid() error {
@@ -29,66 +50,74 @@ func (s *Service) isValid() error {
 	return nil
 }
 
-func (s *Service) Deploy(svc *ecs.ECS) (*ecs.Service, error) {
+func (s *Service) Deploy(svc ecsiface.ECSAPI) (*ecs.Service, error) {
 	if err := s.isValid(); err != nil {
 		return nil, err
 	}
 
 	// check availability of service
-	srvout, err := svc.DescribeServices(&ecs.DescribeServicesInput{
-		Cluster:  s.Cluster,
-		Services: []*string{&s.Service},
-	})
+	srv, err := findService(aws.BackgroundContext(), svc, s.Cluster, s.Service)
 	if err != nil {
 		return nil, err
 	}
 
-	if len(srvout.Services) == 0 {
+	if srv == nil {
+		if s.CreateIfMissing {
+			return s.Create(svc)
+		}
 		return nil, fmt.Errorf("Cluster/Service combination not found")
-	} else if len(srvout.Services) > 1 {
-		return nil, fmt.Errorf("You can only update exactly 1 Service")
 	}
-	srv := srvout.Services[0]
 
 	if s.TaskDefinition != nil {
 		if s.TaskDefinition.Family == "" {
 			s.TaskDefinition.Family = *srv.TaskDefinition
 		}
+		s.TaskDefinition.DryRun = s.TaskDefinition.DryRun || s.DryRun
+		s.TaskDefinition.logger = s.log()
 		s.taskDefinition, err = s.TaskDefinition.Register(svc)
 		if err != nil {
 			return nil, err
 		}
 	}
 
-	fmt.Printf("Deploying Service [%s]...\n", s.Service)
+	s.NetworkConfiguration = mergeNetworkConfiguration(s.NetworkConfiguration, srv.NetworkConfiguration)
+	if err := checkNetworkConfiguration(s.NetworkConfiguration); err != nil {
+		return nil, err
+	}
+
 	input := s.unpackUpdateInput()
+	if s.DryRun {
+		s.plan(srv, input)
+		return srv, nil
+	}
+
+	s.log().Printf("Deploying Service [%s]...\n", s.Service)
 	snew, err := svc.UpdateService(input)
 	if err != nil {
 		return nil, err
 	}
 
-	fmt.Printf("Successfully deployed [%s]\n\n", *snew.Service.ServiceName)
+	s.log().Printf("Successfully deployed [%s]\n\n", *snew.Service.ServiceName)
 	return snew.Service, nil
 }
 
-func (s *Service) Update(svc *ecs.ECS) (*ecs.Service, error) {
+func (s *Service) Update(svc ecsiface.ECSAPI) (*ecs.Service, error) {
 	if err := s.isValid(); err != nil {
 		return nil, err
 	}
 
 	// check availability of service
-	srvout, err := svc.DescribeServices(&ecs.DescribeServicesInput{
-		Cluster:  s.Cluster,
-		Services: []*string{&s.Service},
-	})
+	srv, err := findService(aws.BackgroundContext(), svc, s.Cluster, s.Service)
 	if err != nil {
 		return nil, err
 	}
 
-	if len(srvout.Services) != 1 {
+	if srv == nil {
+		if s.CreateIfMissing {
+			return s.Create(svc)
+		}
 		return nil, fmt.Errorf("You can only update exactly 1 Service")
 	}
-	srv := srvout.Services[0]
 
 	if s.TaskDefinition != nil {
 		if s.TaskDefinition.Family == "" {
@@ -106,23 +135,105 @@ func (s *Service) Update(svc *ecs.ECS) (*ecs.Service, error) {
 				return nil, fmt.Errorf("You cannot change the task definition during and update operation")
 			}
 		}
+		s.TaskDefinition.DryRun = s.TaskDefinition.DryRun || s.DryRun
+		s.TaskDefinition.logger = s.log()
 		s.taskDefinition, err = s.TaskDefinition.Update(svc)
 		if err != nil {
 			return nil, err
 		}
 	}
 
-	fmt.Printf("Updating Service [%s]...\n", s.Service)
+	s.NetworkConfiguration = mergeNetworkConfiguration(s.NetworkConfiguration, srv.NetworkConfiguration)
+	if err := checkNetworkConfiguration(s.NetworkConfiguration); err != nil {
+		return nil, err
+	}
+
 	input := s.unpackUpdateInput()
+	if s.DryRun {
+		s.plan(srv, input)
+		return srv, nil
+	}
+
+	s.log().Printf("Updating Service [%s]...\n", s.Service)
 	snew, err := svc.UpdateService(input)
 	if err != nil {
 		return nil, err
 	}
 
-	fmt.Printf("Successfully updated [%s]\n\n", *snew.Service.ServiceName)
+	s.log().Printf("Successfully updated [%s]\n\n", *snew.Service.ServiceName)
+	return snew.Service, nil
+}
+
+func (s *Service) Create(svc ecsiface.ECSAPI) (*ecs.Service, error) {
+	if err := s.isValid(); err != nil {
+		return nil, err
+	}
+
+	if s.TaskDefinition == nil || s.TaskDefinition.Family == "" {
+		return nil, fmt.Errorf("Service cannot be created without a Task Definition family")
+	}
+
+	if err := checkNetworkConfiguration(s.NetworkConfiguration); err != nil {
+		return nil, err
+	}
+
+	var err error
+	s.TaskDefinition.DryRun = s.TaskDefinition.DryRun || s.DryRun
+	s.TaskDefinition.logger = s.log()
+	s.taskDefinition, err = s.TaskDefinition.Register(svc)
+	if err != nil {
+		return nil, err
+	}
+
+	input := s.unpackCreateInput()
+	if s.DryRun {
+		if input.TaskDefinition == nil && s.taskDefinition.Family != nil {
+			input.TaskDefinition = aws.String(fmt.Sprintf("%s:(new revision)", *s.taskDefinition.Family))
+		}
+		s.log().Printf("Planned creation of Service [%s]:\n", s.Service)
+		printDiff(s.log(), diff(&ecs.CreateServiceInput{}, input))
+		return &ecs.Service{ServiceName: &s.Service, TaskDefinition: input.TaskDefinition}, nil
+	}
+
+	s.log().Printf("Creating Service [%s]...\n", s.Service)
+	snew, err := svc.CreateService(input)
+	if err != nil {
+		return nil, err
+	}
+
+	s.log().Printf("Successfully created [%s]\n\n", *snew.Service.ServiceName)
 	return snew.Service, nil
 }
 
+func (s *Service) unpackCreateInput() *ecs.CreateServiceInput {
+	createServiceInput := &ecs.CreateServiceInput{}
+
+	createServiceInput.Cluster = s.Cluster
+	createServiceInput.ServiceName = &s.Service
+	createServiceInput.PlatformVersion = s.PlatformVersion
+	createServiceInput.NetworkConfiguration = s.NetworkConfiguration
+	if s.taskDefinition != nil {
+		createServiceInput.TaskDefinition = s.taskDefinition.TaskDefinitionArn
+	}
+	createServiceInput.DeploymentConfiguration = s.DeploymentConfiguration
+	createServiceInput.DesiredCount = s.DesiredCount
+	createServiceInput.HealthCheckGracePeriodSeconds = s.HealthCheckGracePeriodSeconds
+	createServiceInput.LaunchType = s.LaunchType
+	createServiceInput.CapacityProviderStrategy = s.CapacityProviderStrategy
+	createServiceInput.SchedulingStrategy = s.SchedulingStrategy
+	createServiceInput.DeploymentController = s.DeploymentController
+	createServiceInput.LoadBalancers = s.LoadBalancers
+	createServiceInput.ServiceRegistries = s.ServiceRegistries
+	createServiceInput.PlacementConstraints = s.PlacementConstraints
+	createServiceInput.PlacementStrategy = s.PlacementStrategy
+	createServiceInput.Role = s.Role
+	createServiceInput.EnableECSManagedTags = s.EnableECSManagedTags
+	createServiceInput.PropagateTags = s.PropagateTags
+	createServiceInput.Tags = s.Tags
+
+	return createServiceInput
+}
+
 func (s *Service) unpackUpdateInput() *ecs.UpdateServiceInput {
 	updateServiceInput := &ecs.UpdateServiceInput{}
 
@@ -140,3 +251,78 @@ func (s *Service) unpackUpdateInput() *ecs.UpdateServiceInput {
 
 	return updateServiceInput
 }
+
+// plan prints the changes input would make to the running Service.
+func (s *Service) plan(srv *ecs.Service, input *ecs.UpdateServiceInput) {
+	current := &ecs.UpdateServiceInput{}
+	current.Cluster = input.Cluster
+	current.Service = input.Service
+	current.PlatformVersion = srv.PlatformVersion
+	current.NetworkConfiguration = srv.NetworkConfiguration
+	current.TaskDefinition = srv.TaskDefinition
+	current.DeploymentConfiguration = srv.DeploymentConfiguration
+	current.DesiredCount = srv.DesiredCount
+	current.HealthCheckGracePeriodSeconds = srv.HealthCheckGracePeriodSeconds
+
+	planned := *current
+	if input.PlatformVersion != nil {
+		planned.PlatformVersion = input.PlatformVersion
+	}
+	if input.NetworkConfiguration != nil {
+		planned.NetworkConfiguration = input.NetworkConfiguration
+	}
+	if input.TaskDefinition != nil {
+		planned.TaskDefinition = input.TaskDefinition
+	} else if s.taskDefinition != nil && s.taskDefinition.Family != nil {
+		planned.TaskDefinition = aws.String(fmt.Sprintf("%s:(new revision)", *s.taskDefinition.Family))
+	}
+	if input.ForceNewDeployment != nil {
+		planned.ForceNewDeployment = input.ForceNewDeployment
+	}
+	if input.DeploymentConfiguration != nil {
+		planned.DeploymentConfiguration = input.DeploymentConfiguration
+	}
+	if input.DesiredCount != nil {
+		planned.DesiredCount = input.DesiredCount
+	}
+	if input.HealthCheckGracePeriodSeconds != nil {
+		planned.HealthCheckGracePeriodSeconds = input.HealthCheckGracePeriodSeconds
+	}
+
+	s.log().Printf("Planned changes to Service [%s]:\n", s.Service)
+	printDiff(s.log(), diff(current, &planned))
+}
+
+// mergeNetworkConfiguration fills the awsvpc settings missing from spec with
+// those of current, so that a single setting can be changed on its own.
+func mergeNetworkConfiguration(spec, current *ecs.NetworkConfiguration) *ecs.NetworkConfiguration {
+	if spec == nil || spec.AwsvpcConfiguration == nil {
+		return spec
+	}
+	if current == nil || current.AwsvpcConfiguration == nil {
+		return spec
+	}
+
+	vpc := *spec.AwsvpcConfiguration
+	if len(vpc.Subnets) == 0 {
+		vpc.Subnets = current.AwsvpcConfiguration.Subnets
+	}
+	if len(vpc.SecurityGroups) == 0 {
+		vpc.SecurityGroups = current.AwsvpcConfiguration.SecurityGroups
+	}
+	if vpc.AssignPublicIp == nil {
+		vpc.AssignPublicIp = current.AwsvpcConfiguration.AssignPublicIp
+	}
+
+	return &ecs.NetworkConfiguration{AwsvpcConfiguration: &vpc}
+}
+
+// checkNetworkConfiguration rejects awsvpc settings without subnets, which
+// ECS would reject anyway.
+func checkNetworkConfiguration(nc *ecs.NetworkConfiguration) error {
+	if nc != nil && nc.AwsvpcConfiguration != nil && len(nc.AwsvpcConfiguration.Subnets) == 0 {
+		return fmt.Errorf("Network configuration must have at least one subnet")
+	}
+
+	return nil
+}
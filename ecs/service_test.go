@@ -0,0 +1,138 @@
+package ecs
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ecs"
+)
+
+func TestUpdateServiceNetworkConfiguration(t *testing.T) {
+	vpc := func(subnets, groups []string, public string) *ecs.NetworkConfiguration {
+		c := &ecs.AwsVpcConfiguration{Subnets: aws.StringSlice(subnets), SecurityGroups: aws.StringSlice(groups)}
+		if public != "" {
+			c.AssignPublicIp = aws.String(public)
+		}
+		return &ecs.NetworkConfiguration{AwsvpcConfiguration: c}
+	}
+
+	tests := []struct {
+		name    string
+		current *ecs.NetworkConfiguration
+		spec    *ecs.NetworkConfiguration
+		want    *ecs.NetworkConfiguration
+		err     string
+	}{
+		{
+			name:    "security groups only",
+			current: vpc([]string{"subnet-1"}, []string{"sg-1"}, "DISABLED"),
+			spec:    &ecs.NetworkConfiguration{AwsvpcConfiguration: &ecs.AwsVpcConfiguration{SecurityGroups: aws.StringSlice([]string{"sg-2"})}},
+			want:    vpc([]string{"subnet-1"}, []string{"sg-2"}, "DISABLED"),
+		},
+		{
+			name:    "public ip only",
+			current: vpc([]string{"subnet-1"}, []string{"sg-1"}, ""),
+			spec:    &ecs.NetworkConfiguration{AwsvpcConfiguration: &ecs.AwsVpcConfiguration{AssignPublicIp: aws.String("ENABLED")}},
+			want:    vpc([]string{"subnet-1"}, []string{"sg-1"}, "ENABLED"),
+		},
+		{
+			name:    "no subnets anywhere",
+			current: nil,
+			spec:    &ecs.NetworkConfiguration{AwsvpcConfiguration: &ecs.AwsVpcConfiguration{SecurityGroups: aws.StringSlice([]string{"sg-2"})}},
+			err:     "must have at least one subnet",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := newFakeECS()
+			f.addService("web", "web:1", 1)
+			f.services["web"].NetworkConfiguration = tt.current
+
+			s := Service{
+				logged:               logged{logger: discard},
+				Cluster:              aws.String("default"),
+				Service:              "web",
+				NetworkConfiguration: tt.spec,
+			}
+			_, err := s.Update(f)
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("Update() = %v, want %q", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if got := f.services["web"].NetworkConfiguration; !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("NetworkConfiguration = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestUpdateServiceCreateIfMissing(t *testing.T) {
+	tests := []struct {
+		name   string
+		dryRun bool
+		status string
+	}{
+		{name: "created and steady", status: StatusSucceeded},
+		{name: "dry run", dryRun: true, status: StatusPlanned},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := newFakeECS()
+			p := &ServicePlugin{
+				ECS:          f,
+				Logger:       discard,
+				WaitStrategy: WaitSteadyState,
+				PollInterval: 10 * time.Millisecond,
+				Service: Service{
+					DryRun:          tt.dryRun,
+					CreateIfMissing: true,
+					Cluster:         aws.String("default"),
+					Service:         "web",
+					DesiredCount:    aws.Int64(2),
+					LaunchType:      aws.String(ecs.LaunchTypeFargate),
+					NetworkConfiguration: &ecs.NetworkConfiguration{AwsvpcConfiguration: &ecs.AwsVpcConfiguration{
+						Subnets: aws.StringSlice([]string{"subnet-1"}),
+					}},
+					TaskDefinition: &TaskDefinition{Family: "web", ContainerDefinitions: []*ContainerDefinition{
+						{Name: "web", Image: aws.String("web:1")},
+					}},
+				},
+			}
+
+			if err := p.UpdateService(5); err != nil {
+				t.Fatal(err)
+			}
+			if p.Result.Status != tt.status {
+				t.Errorf("Status = %s, want %s", p.Result.Status, tt.status)
+			}
+
+			srv, ok := f.services["web"]
+			if tt.dryRun {
+				if ok || len(f.taskDefinitions["web"]) != 0 {
+					t.Errorf("dry run created the Service or a revision")
+				}
+				return
+			}
+			if !ok {
+				t.Fatal("Service was not created")
+			}
+			if p.Result.TaskDefinitionArn != *srv.TaskDefinition {
+				t.Errorf("TaskDefinitionArn = %s, want %s", p.Result.TaskDefinitionArn, *srv.TaskDefinition)
+			}
+			if aws.StringValue(srv.LaunchType) != ecs.LaunchTypeFargate || aws.Int64Value(srv.RunningCount) != 2 {
+				t.Errorf("Service = %v, want 2 FARGATE tasks running", srv)
+			}
+		})
+	}
+}
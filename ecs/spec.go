@@ -0,0 +1,66 @@
+package ecs
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+
+	"github.com/ghodss/yaml"
+)
+
+// LoadTaskDefinition reads a JSON or YAML task definition spec, in the same
+// shape as `aws ecs register-task-definition --cli-input-json`.
+func LoadTaskDefinition(path string) (*TaskDefinition, error) {
+	td := &TaskDefinition{}
+	if err := loadSpec(path, td); err != nil {
+		return nil, err
+	}
+
+	return td, nil
+}
+
+// LoadService reads a JSON or YAML service spec, in the same shape as
+// `aws ecs create-service --cli-input-json`. The task definition may be
+// given inline as a task definition spec.
+func LoadService(path string) (*Service, error) {
+	s := &Service{}
+	if err := loadSpec(path, s); err != nil {
+		return nil, err
+	}
+
+	return s, nil
+}
+
+// UnmarshalJSON also accepts a family, family:revision or ARN string, which
+// is how service specs usually refer to an existing task definition.
+func (td *TaskDefinition) UnmarshalJSON(data []byte) error {
+	var family string
+	if err := json.Unmarshal(data, &family); err == nil {
+		*td = TaskDefinition{Family: family}
+		return nil
+	}
+
+	type taskDefinition TaskDefinition
+	return json.Unmarshal(data, (*taskDefinition)(td))
+}
+
+func loadSpec(path string, v interface{}) error {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".json":
+		err = json.Unmarshal(data, v)
+	default:
+		err = yaml.Unmarshal(data, v)
+	}
+	if err != nil {
+		return fmt.Errorf("Spec file [%s] cannot be parsed: %s", path, err)
+	}
+
+	return nil
+}
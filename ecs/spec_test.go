@@ -0,0 +1,172 @@
+package ecs
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ecs"
+)
+
+// writeSpec writes content to a file named name in a new directory, which
+// the returned function removes.
+func writeSpec(t *testing.T, name, content string) (string, func()) {
+	dir, err := ioutil.TempDir("", "spec")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	path := filepath.Join(dir, name)
+	if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+		os.RemoveAll(dir)
+		t.Fatal(err)
+	}
+
+	return path, func() { os.RemoveAll(dir) }
+}
+
+func TestLoadService(t *testing.T) {
+	tests := []struct {
+		name    string
+		file    string
+		content string
+		family  string
+		inline  bool
+	}{
+		{
+			name:    "task definition as a string in JSON",
+			file:    "service.json",
+			content: `{"serviceName": "web", "desiredCount": 2, "launchType": "FARGATE", "taskDefinition": "web:3"}`,
+			family:  "web:3",
+		},
+		{
+			name:    "task definition as an ARN in YAML",
+			file:    "service.yml",
+			content: "serviceName: web\ndesiredCount: 2\nlaunchType: FARGATE\ntaskDefinition: arn:aws:ecs:us-east-1:123456789012:task-definition/web:3\n",
+			family:  "arn:aws:ecs:us-east-1:123456789012:task-definition/web:3",
+		},
+		{
+			name:    "inline task definition",
+			file:    "service.yaml",
+			content: "serviceName: web\ndesiredCount: 2\nlaunchType: FARGATE\ntaskDefinition:\n  family: web\n  containerDefinitions:\n  - name: web\n    image: web:1\n",
+			family:  "web",
+			inline:  true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path, remove := writeSpec(t, tt.file, tt.content)
+			defer remove()
+
+			s, err := LoadService(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if s.Service != "web" || aws.Int64Value(s.DesiredCount) != 2 || aws.StringValue(s.LaunchType) != "FARGATE" {
+				t.Errorf("LoadService() = %+v, want web with 2 FARGATE tasks", s)
+			}
+			if s.TaskDefinition == nil || s.TaskDefinition.Family != tt.family {
+				t.Fatalf("TaskDefinition = %+v, want family %s", s.TaskDefinition, tt.family)
+			}
+			if inline := len(s.TaskDefinition.ContainerDefinitions) > 0; inline != tt.inline {
+				t.Errorf("inline Container Definitions = %v, want %v", inline, tt.inline)
+			}
+		})
+	}
+}
+
+func TestLoadTaskDefinition(t *testing.T) {
+	specs := map[string]string{
+		"task.json": `{
+  "family": "web",
+  "cpu": "1024",
+  "memory": "2048",
+  "ephemeralStorage": {"sizeInGiB": 50},
+  "runtimePlatform": {"cpuArchitecture": "ARM64", "operatingSystemFamily": "LINUX"},
+  "inferenceAccelerators": [{"deviceName": "device1", "deviceType": "eia2.medium"}],
+  "proxyConfiguration": {"type": "APPMESH", "containerName": "envoy"},
+  "containerDefinitions": [{
+    "name": "web",
+    "image": "web:1",
+    "environmentFiles": [{"type": "s3", "value": "arn:aws:s3:::config/web.env"}],
+    "portMappings": [{"containerPort": 8080}]
+  }]
+}`,
+		"task.yaml": `family: web
+cpu: "1024"
+memory: "2048"
+ephemeralStorage:
+  sizeInGiB: 50
+runtimePlatform:
+  cpuArchitecture: ARM64
+  operatingSystemFamily: LINUX
+inferenceAccelerators:
+- deviceName: device1
+  deviceType: eia2.medium
+proxyConfiguration:
+  type: APPMESH
+  containerName: envoy
+containerDefinitions:
+- name: web
+  image: web:1
+  environmentFiles:
+  - type: s3
+    value: arn:aws:s3:::config/web.env
+  portMappings:
+  - containerPort: 8080
+`,
+	}
+
+	want := &ecs.RegisterTaskDefinitionInput{
+		Family:                aws.String("web"),
+		Cpu:                   aws.String("1024"),
+		Memory:                aws.String("2048"),
+		EphemeralStorage:      &ecs.EphemeralStorage{SizeInGiB: aws.Int64(50)},
+		RuntimePlatform:       &ecs.RuntimePlatform{CpuArchitecture: aws.String("ARM64"), OperatingSystemFamily: aws.String("LINUX")},
+		InferenceAccelerators: []*ecs.InferenceAccelerator{{DeviceName: aws.String("device1"), DeviceType: aws.String("eia2.medium")}},
+		ProxyConfiguration:    &ecs.ProxyConfiguration{Type: aws.String("APPMESH"), ContainerName: aws.String("envoy")},
+		ContainerDefinitions: []*ecs.ContainerDefinition{{
+			Name:             aws.String("web"),
+			Image:            aws.String("web:1"),
+			EnvironmentFiles: []*ecs.EnvironmentFile{{Type: aws.String("s3"), Value: aws.String("arn:aws:s3:::config/web.env")}},
+			PortMappings:     []*ecs.PortMapping{{ContainerPort: aws.Int64(8080)}},
+		}},
+	}
+
+	for name, content := range specs {
+		t.Run(name, func(t *testing.T) {
+			path, remove := writeSpec(t, name, content)
+			defer remove()
+
+			td, err := LoadTaskDefinition(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			input := td.generateInput(nil, nil)
+			if !reflect.DeepEqual(input, want) {
+				t.Fatalf("generateInput() = %v, want %v", input, want)
+			}
+
+			// the registered input loads back as the same spec
+			b, err := json.Marshal(input)
+			if err != nil {
+				t.Fatal(err)
+			}
+			again, remove := writeSpec(t, "again.json", string(b))
+			defer remove()
+			td, err = LoadTaskDefinition(again)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if input := td.generateInput(nil, nil); !reflect.DeepEqual(input, want) {
+				t.Errorf("round trip = %v, want %v", input, want)
+			}
+		})
+	}
+}
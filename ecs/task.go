@@ -0,0 +1,163 @@
+package ecs
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ecs"
+	"github.com/aws/aws-sdk-go/service/ecs/ecsiface"
+)
+
+// Task is a one-off run of a Task Definition, such as a database migration.
+// When Service is set, its Task Definition family, network configuration and
+// launch type are used unless given explicitly.
+type Task struct {
+	logged
+
+	Cluster *string
+	Service *string
+
+	ContainerName string
+	Command       []*string
+	Environment   []*ecs.KeyValuePair
+
+	LaunchType               *string
+	CapacityProviderStrategy []*ecs.CapacityProviderStrategyItem
+	NetworkConfiguration     *ecs.NetworkConfiguration
+	PlatformVersion          *string
+	StartedBy                *string
+
+	taskDefinition *ecs.TaskDefinition
+}
+
+func (t *Task) Run(svc ecsiface.ECSAPI, td *TaskDefinition) (*ecs.Task, error) {
+	if t.Service != nil {
+		srv, err := describeService(aws.BackgroundContext(), svc, t.Cluster, *t.Service)
+		if err != nil {
+			return nil, err
+		}
+
+		if td.Family == "" {
+			td.Family = *srv.TaskDefinition
+		}
+		if t.NetworkConfiguration == nil {
+			t.NetworkConfiguration = srv.NetworkConfiguration
+		} else {
+			t.NetworkConfiguration = mergeNetworkConfiguration(t.NetworkConfiguration, srv.NetworkConfiguration)
+		}
+		if t.LaunchType == nil && t.CapacityProviderStrategy == nil {
+			t.LaunchType = srv.LaunchType
+			t.CapacityProviderStrategy = srv.CapacityProviderStrategy
+		}
+		if t.PlatformVersion == nil {
+			t.PlatformVersion = srv.PlatformVersion
+		}
+	}
+
+	if err := checkNetworkConfiguration(t.NetworkConfiguration); err != nil {
+		return nil, err
+	}
+
+	var err error
+	td.logger = t.log()
+	t.taskDefinition, err = td.Register(svc)
+	if err != nil {
+		return nil, err
+	}
+
+	container, err := t.container()
+	if err != nil {
+		return nil, err
+	}
+
+	input := t.unpackRunInput(container)
+	if td.DryRun {
+		t.log().Printf("Planned run of Task [%s]:\n", *t.taskDefinition.Family)
+		printDiff(t.log(), diff(&ecs.RunTaskInput{}, input))
+		return nil, nil
+	}
+
+	version, _ := parseFamilyRevision(*t.taskDefinition.TaskDefinitionArn)
+	t.log().Printf("Running Task [%s]...\n", version)
+	runout, err := svc.RunTask(input)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(runout.Failures) > 0 {
+		reasons := []string{}
+		for _, f := range runout.Failures {
+			reasons = append(reasons, fmt.Sprintf("%s (%s)", aws.StringValue(f.Reason), aws.StringValue(f.Arn)))
+		}
+		return nil, fmt.Errorf("Task [%s] could not be started: %s", version, strings.Join(reasons, ", "))
+	}
+	if len(runout.Tasks) != 1 {
+		return nil, fmt.Errorf("Task [%s] could not be started", version)
+	}
+
+	t.log().Printf("Successfully started [%s]\n\n", taskID(runout.Tasks[0]))
+	return runout.Tasks[0], nil
+}
+
+// container returns the definition of ContainerName, or of the first
+// essential container when no name was given.
+func (t *Task) container() (*ecs.ContainerDefinition, error) {
+	for _, cd := range t.taskDefinition.ContainerDefinitions {
+		if t.ContainerName != "" && aws.StringValue(cd.Name) == t.ContainerName {
+			return cd, nil
+		}
+		if t.ContainerName == "" && (cd.Essential == nil || *cd.Essential) {
+			return cd, nil
+		}
+	}
+
+	if t.ContainerName != "" {
+		return nil, fmt.Errorf("Container [%s] was not found in the Task Definition", t.ContainerName)
+	}
+	return nil, fmt.Errorf("Task Definition has no essential Container")
+}
+
+// exitCode returns the exit code of the run's container once the task stopped.
+func (t *Task) exitCode(task *ecs.Task) (int64, error) {
+	container, err := t.container()
+	if err != nil {
+		return 0, err
+	}
+
+	for _, c := range task.Containers {
+		if aws.StringValue(c.Name) == aws.StringValue(container.Name) && c.ExitCode != nil {
+			return *c.ExitCode, nil
+		}
+	}
+
+	return 0, fmt.Errorf("Container [%s] stopped without an exit code: %s", aws.StringValue(container.Name), aws.StringValue(task.StoppedReason))
+}
+
+func (t *Task) unpackRunInput(container *ecs.ContainerDefinition) *ecs.RunTaskInput {
+	runTaskInput := &ecs.RunTaskInput{}
+
+	runTaskInput.Cluster = t.Cluster
+	runTaskInput.TaskDefinition = t.taskDefinition.TaskDefinitionArn
+	runTaskInput.LaunchType = t.LaunchType
+	runTaskInput.CapacityProviderStrategy = t.CapacityProviderStrategy
+	runTaskInput.NetworkConfiguration = t.NetworkConfiguration
+	runTaskInput.PlatformVersion = t.PlatformVersion
+	runTaskInput.StartedBy = t.StartedBy
+	if t.Command != nil || t.Environment != nil {
+		runTaskInput.Overrides = &ecs.TaskOverride{
+			ContainerOverrides: []*ecs.ContainerOverride{{
+				Name:        container.Name,
+				Command:     t.Command,
+				Environment: t.Environment,
+			}},
+		}
+	}
+
+	return runTaskInput
+}
+
+func taskID(task *ecs.Task) string {
+	arn := aws.StringValue(task.TaskArn)
+	return arn[strings.LastIndex(arn, "/")+1:]
+}
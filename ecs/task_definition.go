@@ -3,18 +3,45 @@ package ecs
 import (
 	"fmt"
 	"regexp"
+	"sort"
 	"strings"
 
+	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/ecr/ecriface"
 	"github.com/aws/aws-sdk-go/service/ecs"
+	"github.com/aws/aws-sdk-go/service/ecs/ecsiface"
+
+	cred "github.com/carash/ecs-deploy/credential"
 )
 
 type TaskDefinition struct {
-	Overwrite       bool
-	DeleteContainer bool
+	logged
+	// registry, or a client per region created from credential, resolves
+	// image digests. The plugins set them when ResolveImageDigests is set.
+	registry   ecriface.ECRAPI
+	credential *cred.Credential
+	registries map[string]ecriface.ECRAPI
+
+	Overwrite           bool
+	OverwriteTags       bool
+	DeleteContainer     bool
+	AddContainer        bool
+	ResolveImageDigests bool
+	DryRun              bool
+
+	// WaitForImages makes the plugins wait for the ECR images of
+	// ContainerDefinitions and ContainerImages to be pushed before
+	// registering, when set
+	WaitForImages *ImageWait
 
 	Family string
 
+	// ContainerImages sets the image of containers by name, on top of
+	// ContainerDefinitions. The containers must exist in the previous
+	// revision unless AddContainer is set.
+	ContainerImages map[string]string
+
 	TaskRoleArn      *string
 	ExecutionRoleArn *string
 
@@ -22,9 +49,11 @@ type TaskDefinition struct {
 	ContainerDefinitions    []*ContainerDefinition
 	Volumes                 []*ecs.Volume
 	RequiresCompatibilities []*string
+	RuntimePlatform         *ecs.RuntimePlatform
 
-	Cpu    *string
-	Memory *string
+	Cpu              *string
+	Memory           *string
+	EphemeralStorage *ecs.EphemeralStorage
 
 	IpcMode *string
 	PidMode *string
@@ -32,6 +61,8 @@ type TaskDefinition struct {
 	PlacementConstraints []*ecs.TaskDefinitionPlacementConstraint
 	ProxyConfiguration   *ecs.ProxyConfiguration
 
+	InferenceAccelerators []*ecs.InferenceAccelerator
+
 	Tags []*ecs.Tag
 }
 
@@ -46,6 +77,7 @@ func (td *TaskDefinition) isValid() error {
 		if len(td.ContainerDefinitions) < 1 {
 			return fmt.Errorf("Container Definitions must have at least 1 Container")
 		}
+		names := map[string]bool{}
 		for _, cd := range td.ContainerDefinitions {
 			if cd == nil {
 				return fmt.Errorf("Container Definitions cannot have nil value")
@@ -54,20 +86,33 @@ func (td *TaskDefinition) isValid() error {
 					return err
 				}
 			}
+			if names[cd.Name] {
+				return fmt.Errorf("Container Definitions cannot have duplicate name [%s]", cd.Name)
+			}
+			names[cd.Name] = true
+		}
+	}
+	for name, image := range td.ContainerImages {
+		if name == "" {
+			return fmt.Errorf("Container Images must have a container name")
+		}
+		if image == "" {
+			return fmt.Errorf("Container Images must have an image for [%s]", name)
 		}
 	}
 
 	return nil
 }
 
-func (td *TaskDefinition) Register(svc *ecs.ECS) (*ecs.TaskDefinition, error) {
+func (td *TaskDefinition) Register(svc ecsiface.ECSAPI) (*ecs.TaskDefinition, error) {
 	if err := td.isValid(); err != nil {
 		return nil, err
 	}
 
 	var taskDefinition *ecs.TaskDefinition
+	var tags []*ecs.Tag
 	if !td.Overwrite {
-		tdout, err := svc.DescribeTaskDefinition(&ecs.DescribeTaskDefinitionInput{TaskDefinition: &td.Family})
+		tdout, err := svc.DescribeTaskDefinition(td.unpackDescribeInput())
 		if err != nil {
 			if aerr, ok := err.(awserr.Error); ok {
 				if aerr.Code() != ecs.ErrCodeClientException {
@@ -78,27 +123,21 @@ func (td *TaskDefinition) Register(svc *ecs.ECS) (*ecs.TaskDefinition, error) {
 			}
 		}
 
-		taskDefinition = tdout.TaskDefinition
+		if tdout != nil {
+			taskDefinition = tdout.TaskDefinition
+			tags = tdout.Tags
+		}
 	}
 
 	if taskDefinition != nil && td.isEmpty() {
-		fmt.Println("No changes were found, using the latest version of the Task Definition")
+		td.log().Printf("No changes were found, using the latest version of the Task Definition\n")
 		return taskDefinition, nil
 	}
 
-	fmt.Printf("Registering new Task Definition from [%s]...\n", td.Family)
-	input := td.generateInput(taskDefinition)
-	tdnew, err := svc.RegisterTaskDefinition(input)
-	if err != nil {
-		return nil, err
-	}
-
-	version, _ := parseFamilyRevision(*tdnew.TaskDefinition.TaskDefinitionArn)
-	fmt.Printf("Successfully registered [%s]\n\n", version)
-	return tdnew.TaskDefinition, nil
+	return td.register(svc, taskDefinition, tags)
 }
 
-func (td *TaskDefinition) Update(svc *ecs.ECS) (*ecs.TaskDefinition, error) {
+func (td *TaskDefinition) Update(svc ecsiface.ECSAPI) (*ecs.TaskDefinition, error) {
 	if err := td.isValid(); err != nil {
 		return nil, err
 	}
@@ -107,7 +146,7 @@ func (td *TaskDefinition) Update(svc *ecs.ECS) (*ecs.TaskDefinition, error) {
 		return nil, fmt.Errorf("Update cannot be run with Overwrite option, since this can be dangerous")
 	}
 
-	tdout, err := svc.DescribeTaskDefinition(&ecs.DescribeTaskDefinitionInput{TaskDefinition: &td.Family})
+	tdout, err := svc.DescribeTaskDefinition(td.unpackDescribeInput())
 	if err != nil {
 		return nil, err
 	}
@@ -117,41 +156,97 @@ func (td *TaskDefinition) Update(svc *ecs.ECS) (*ecs.TaskDefinition, error) {
 	}
 
 	if td.isEmpty() {
-		fmt.Println("No changes were found, using the latest version of the Task Definition")
+		td.log().Printf("No changes were found, using the latest version of the Task Definition\n")
 		return tdout.TaskDefinition, nil
 	}
 
-	fmt.Printf("Registering new Task Definition from [%s]...\n", td.Family)
-	input := td.generateInput(tdout.TaskDefinition)
+	return td.register(svc, tdout.TaskDefinition, tdout.Tags)
+}
+
+func (td *TaskDefinition) register(svc ecsiface.ECSAPI, old *ecs.TaskDefinition, oldTags []*ecs.Tag) (*ecs.TaskDefinition, error) {
+	if err := td.checkContainerImages(old); err != nil {
+		return nil, err
+	}
+
+	input := td.generateInput(old, oldTags)
+	if td.ResolveImageDigests {
+		cds, err := td.resolveImageDigests(input.ContainerDefinitions)
+		if err != nil {
+			return nil, err
+		}
+		input.ContainerDefinitions = cds
+	}
+	if err := checkContainerVariables(input.ContainerDefinitions); err != nil {
+		return nil, err
+	}
+	if td.DryRun {
+		return td.plan(input, old, oldTags), nil
+	}
+
+	td.log().Printf("Registering new Task Definition from [%s]...\n", td.Family)
 	tdnew, err := svc.RegisterTaskDefinition(input)
 	if err != nil {
 		return nil, err
 	}
 
 	version, _ := parseFamilyRevision(*tdnew.TaskDefinition.TaskDefinitionArn)
-	fmt.Printf("Successfully registered [%s]\n\n", version)
+	td.log().Printf("Successfully registered [%s]\n\n", version)
 	return tdnew.TaskDefinition, nil
 }
 
+// plan prints the changes input would make to the previous revision. The
+// returned Task Definition has no ARN, since nothing was registered.
+func (td *TaskDefinition) plan(input *ecs.RegisterTaskDefinitionInput, old *ecs.TaskDefinition, oldTags []*ecs.Tag) *ecs.TaskDefinition {
+	current := (&TaskDefinition{Family: td.Family}).generateInput(old, oldTags)
+
+	version := *input.Family
+	if old != nil && old.TaskDefinitionArn != nil {
+		version, _ = parseFamilyRevision(*old.TaskDefinitionArn)
+	}
+	td.log().Printf("Planned changes to Task Definition [%s]:\n", version)
+	printDiff(td.log(), diff(current, input))
+
+	return &ecs.TaskDefinition{
+		Family:               input.Family,
+		ContainerDefinitions: input.ContainerDefinitions,
+	}
+}
+
 func (td *TaskDefinition) isEmpty() bool {
 	return !td.Overwrite &&
 		td.TaskRoleArn == nil &&
 		td.ExecutionRoleArn == nil &&
 		td.NetworkMode == nil &&
 		td.ContainerDefinitions == nil &&
+		td.ContainerImages == nil &&
 		td.Volumes == nil &&
 		td.RequiresCompatibilities == nil &&
+		td.RuntimePlatform == nil &&
 		td.Cpu == nil &&
 		td.Memory == nil &&
+		td.EphemeralStorage == nil &&
 		td.IpcMode == nil &&
 		td.PidMode == nil &&
 		td.PlacementConstraints == nil &&
 		td.ProxyConfiguration == nil &&
+		td.InferenceAccelerators == nil &&
 		td.Tags == nil
 }
 
-func (td *TaskDefinition) generateInput(old *ecs.TaskDefinition) *ecs.RegisterTaskDefinitionInput {
+func (td *TaskDefinition) unpackDescribeInput() *ecs.DescribeTaskDefinitionInput {
+	describeTaskDefinitionInput := &ecs.DescribeTaskDefinitionInput{}
+
+	describeTaskDefinitionInput.TaskDefinition = &td.Family
+	describeTaskDefinitionInput.Include = []*string{aws.String(ecs.TaskDefinitionFieldTags)}
+
+	return describeTaskDefinitionInput
+}
+
+func (td *TaskDefinition) generateInput(old *ecs.TaskDefinition, oldTags []*ecs.Tag) *ecs.RegisterTaskDefinitionInput {
 	taskInput := &ecs.RegisterTaskDefinitionInput{}
+	if old == nil {
+		old = &ecs.TaskDefinition{}
+	}
 
 	family, _ := parseFamily(td.Family)
 	taskInput.Family = &family
@@ -172,14 +267,13 @@ func (td *TaskDefinition) generateInput(old *ecs.TaskDefinition) *ecs.RegisterTa
 		taskInput.NetworkMode = old.NetworkMode
 	}
 	if td.ContainerDefinitions != nil {
-		containerDefinitions := []*ecs.ContainerDefinition{}
-		for i, cd := range td.ContainerDefinitions {
-			containerDefinitions = append(containerDefinitions, cd.generateDefinition(old.ContainerDefinitions[i]))
-		}
-		taskInput.ContainerDefinitions = containerDefinitions
+		taskInput.ContainerDefinitions = td.mergeContainerDefinitions(old.ContainerDefinitions)
 	} else {
 		taskInput.ContainerDefinitions = old.ContainerDefinitions
 	}
+	if td.ContainerImages != nil {
+		taskInput.ContainerDefinitions = td.setContainerImages(taskInput.ContainerDefinitions)
+	}
 	if td.Volumes != nil {
 		taskInput.Volumes = td.Volumes
 	} else {
@@ -190,6 +284,11 @@ func (td *TaskDefinition) generateInput(old *ecs.TaskDefinition) *ecs.RegisterTa
 	} else {
 		taskInput.RequiresCompatibilities = old.RequiresCompatibilities
 	}
+	if td.RuntimePlatform != nil {
+		taskInput.RuntimePlatform = td.RuntimePlatform
+	} else {
+		taskInput.RuntimePlatform = old.RuntimePlatform
+	}
 	if td.Cpu != nil {
 		taskInput.Cpu = td.Cpu
 	} else {
@@ -200,6 +299,11 @@ func (td *TaskDefinition) generateInput(old *ecs.TaskDefinition) *ecs.RegisterTa
 	} else {
 		taskInput.Memory = old.Memory
 	}
+	if td.EphemeralStorage != nil {
+		taskInput.EphemeralStorage = td.EphemeralStorage
+	} else {
+		taskInput.EphemeralStorage = old.EphemeralStorage
+	}
 	if td.IpcMode != nil {
 		taskInput.IpcMode = td.IpcMode
 	} else {
@@ -220,11 +324,161 @@ func (td *TaskDefinition) generateInput(old *ecs.TaskDefinition) *ecs.RegisterTa
 	} else {
 		taskInput.ProxyConfiguration = old.ProxyConfiguration
 	}
+	if td.InferenceAccelerators != nil {
+		taskInput.InferenceAccelerators = td.InferenceAccelerators
+	} else {
+		taskInput.InferenceAccelerators = old.InferenceAccelerators
+	}
+	if td.Tags != nil {
+		taskInput.Tags = td.mergeTags(oldTags)
+	} else if len(oldTags) > 0 {
+		taskInput.Tags = oldTags
+	}
 
 	return taskInput
 }
 
-var arnRegex, _ = regexp.Compile(`^arn:aws:ecs:[a-z]{2}-[a-z]+-\d{1,2}:\d{12}:task-definition\/[\w-]+:\d+$`)
+// mergeTags overrides the previous revision's tags by key, unless
+// OverwriteTags is set, in which case only the spec tags are kept.
+func (td *TaskDefinition) mergeTags(old []*ecs.Tag) []*ecs.Tag {
+	if td.OverwriteTags {
+		return td.Tags
+	}
+
+	specs := map[string]*ecs.Tag{}
+	for _, t := range td.Tags {
+		if t != nil && t.Key != nil {
+			specs[*t.Key] = t
+		}
+	}
+
+	tags := []*ecs.Tag{}
+	merged := map[string]bool{}
+	for _, ot := range old {
+		if ot == nil || ot.Key == nil {
+			continue
+		}
+
+		if t, ok := specs[*ot.Key]; ok {
+			tags = append(tags, t)
+			merged[*ot.Key] = true
+		} else {
+			tags = append(tags, ot)
+		}
+	}
+
+	for _, t := range td.Tags {
+		if t != nil && t.Key != nil && !merged[*t.Key] {
+			tags = append(tags, t)
+		}
+	}
+
+	return tags
+}
+
+// mergeContainerDefinitions pairs the spec containers with the previous
+// revision by name. Existing containers keep their registered order, new
+// names are appended, and unlisted containers are kept unless DeleteContainer
+// is set.
+func (td *TaskDefinition) mergeContainerDefinitions(old []*ecs.ContainerDefinition) []*ecs.ContainerDefinition {
+	specs := map[string]*ContainerDefinition{}
+	for _, cd := range td.ContainerDefinitions {
+		specs[cd.Name] = cd
+	}
+
+	containerDefinitions := []*ecs.ContainerDefinition{}
+	merged := map[string]bool{}
+	for _, ocd := range old {
+		if ocd == nil || ocd.Name == nil {
+			continue
+		}
+
+		if cd, ok := specs[*ocd.Name]; ok {
+			containerDefinitions = append(containerDefinitions, cd.generateDefinition(ocd))
+			merged[cd.Name] = true
+		} else if !td.DeleteContainer {
+			containerDefinitions = append(containerDefinitions, ocd)
+		}
+	}
+
+	for _, cd := range td.ContainerDefinitions {
+		if !merged[cd.Name] {
+			containerDefinitions = append(containerDefinitions, cd.generateDefinition(nil))
+		}
+	}
+
+	return containerDefinitions
+}
+
+// checkContainerImages fails when ContainerImages names a container that is
+// neither in the previous revision nor in the spec, unless AddContainer is set.
+func (td *TaskDefinition) checkContainerImages(old *ecs.TaskDefinition) error {
+	if td.AddContainer {
+		return nil
+	}
+
+	existing := map[string]bool{}
+	if old != nil {
+		for _, ocd := range old.ContainerDefinitions {
+			if ocd != nil && ocd.Name != nil {
+				existing[*ocd.Name] = true
+			}
+		}
+	}
+	for _, cd := range td.ContainerDefinitions {
+		existing[cd.Name] = true
+	}
+
+	missing := []string{}
+	for name := range td.ContainerImages {
+		if !existing[name] {
+			missing = append(missing, name)
+		}
+	}
+	if len(missing) > 0 {
+		sort.Strings(missing)
+		return fmt.Errorf("Container [%s] does not exist in Task Definition [%s], enable adding containers to add it", strings.Join(missing, ", "), td.Family)
+	}
+
+	return nil
+}
+
+// setContainerImages replaces the images of the named containers, without
+// modifying the given definitions, and appends the names it did not find as
+// new containers.
+func (td *TaskDefinition) setContainerImages(cds []*ecs.ContainerDefinition) []*ecs.ContainerDefinition {
+	containerDefinitions := []*ecs.ContainerDefinition{}
+	set := map[string]bool{}
+	for _, cd := range cds {
+		if cd != nil && cd.Name != nil {
+			if image, ok := td.ContainerImages[*cd.Name]; ok {
+				c := *cd
+				c.Image = aws.String(image)
+				cd = &c
+				set[*cd.Name] = true
+			}
+		}
+		containerDefinitions = append(containerDefinitions, cd)
+	}
+
+	names := []string{}
+	for name := range td.ContainerImages {
+		if !set[name] {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		containerDefinitions = append(containerDefinitions, &ecs.ContainerDefinition{
+			Name:  aws.String(name),
+			Image: aws.String(td.ContainerImages[name]),
+		})
+	}
+
+	return containerDefinitions
+}
+
+var arnRegex, _ = regexp.Compile(`^arn:aws[a-z-]*:ecs:[a-z]{2}(-[a-z]+)+-\d{1,2}:\d{12}:task-definition\/[\w-]+:\d+$`)
 var familyRegex, _ = regexp.Compile(`^[\w-]+$`)
 var familyRevisionRegex, _ = regexp.Compile(`^[\w-]+:\d+$`)
 
@@ -0,0 +1,392 @@
+package ecs
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ecs"
+)
+
+func names(cds []*ecs.ContainerDefinition) []string {
+	ns := []string{}
+	for _, cd := range cds {
+		ns = append(ns, aws.StringValue(cd.Name))
+	}
+
+	return ns
+}
+
+func tagMap(tags []*ecs.Tag) map[string]string {
+	m := map[string]string{}
+	for _, t := range tags {
+		m[aws.StringValue(t.Key)] = aws.StringValue(t.Value)
+	}
+
+	return m
+}
+
+func TestGenerateInput(t *testing.T) {
+	old := &ecs.TaskDefinition{
+		Family:      aws.String("web"),
+		TaskRoleArn: aws.String("arn:aws:iam::123456789012:role/web"),
+		Cpu:         aws.String("256"),
+		ContainerDefinitions: []*ecs.ContainerDefinition{
+			{Name: aws.String("app"), Image: aws.String("app:1"), Memory: aws.Int64(512)},
+			{Name: aws.String("proxy"), Image: aws.String("proxy:1")},
+		},
+	}
+	oldTags := []*ecs.Tag{
+		{Key: aws.String("team"), Value: aws.String("core")},
+		{Key: aws.String("env"), Value: aws.String("staging")},
+	}
+
+	tests := []struct {
+		name   string
+		td     TaskDefinition
+		old    *ecs.TaskDefinition
+		images map[string]string
+		memory map[string]int64
+		tags   map[string]string
+		cpu    string
+	}{
+		{
+			name:   "inherits the previous revision",
+			td:     TaskDefinition{Family: "web"},
+			old:    old,
+			images: map[string]string{"app": "app:1", "proxy": "proxy:1"},
+			memory: map[string]int64{"app": 512},
+			tags:   map[string]string{"team": "core", "env": "staging"},
+			cpu:    "256",
+		},
+		{
+			name: "keeps the registered container order",
+			td: TaskDefinition{Family: "web", ContainerDefinitions: []*ContainerDefinition{
+				{Name: "proxy", Image: aws.String("proxy:2")},
+				{Name: "app", Image: aws.String("app:2")},
+			}},
+			old:    old,
+			images: map[string]string{"app": "app:2", "proxy": "proxy:2"},
+			memory: map[string]int64{"app": 512},
+			tags:   map[string]string{"team": "core", "env": "staging"},
+			cpu:    "256",
+		},
+		{
+			name: "appends new containers and keeps unlisted ones",
+			td: TaskDefinition{Family: "web", ContainerDefinitions: []*ContainerDefinition{
+				{Name: "worker", Image: aws.String("worker:1")},
+			}},
+			old:    old,
+			images: map[string]string{"app": "app:1", "proxy": "proxy:1", "worker": "worker:1"},
+			memory: map[string]int64{"app": 512},
+			tags:   map[string]string{"team": "core", "env": "staging"},
+			cpu:    "256",
+		},
+		{
+			name: "deletes unlisted containers",
+			td: TaskDefinition{Family: "web", DeleteContainer: true, ContainerDefinitions: []*ContainerDefinition{
+				{Name: "app", Image: aws.String("app:2")},
+			}},
+			old:    old,
+			images: map[string]string{"app": "app:2"},
+			memory: map[string]int64{"app": 512},
+			tags:   map[string]string{"team": "core", "env": "staging"},
+			cpu:    "256",
+		},
+		{
+			name: "overwrites a single container",
+			td: TaskDefinition{Family: "web", ContainerDefinitions: []*ContainerDefinition{
+				{Name: "app", Image: aws.String("app:2"), Overwrite: true},
+			}},
+			old:    old,
+			images: map[string]string{"app": "app:2", "proxy": "proxy:1"},
+			memory: map[string]int64{},
+			tags:   map[string]string{"team": "core", "env": "staging"},
+			cpu:    "256",
+		},
+		{
+			name: "merges tags by key",
+			td: TaskDefinition{Family: "web", Cpu: aws.String("512"), Tags: []*ecs.Tag{
+				{Key: aws.String("env"), Value: aws.String("production")},
+				{Key: aws.String("owner"), Value: aws.String("ops")},
+			}},
+			old:    old,
+			images: map[string]string{"app": "app:1", "proxy": "proxy:1"},
+			memory: map[string]int64{"app": 512},
+			tags:   map[string]string{"team": "core", "env": "production", "owner": "ops"},
+			cpu:    "512",
+		},
+		{
+			name: "overwrites tags",
+			td: TaskDefinition{Family: "web", OverwriteTags: true, Tags: []*ecs.Tag{
+				{Key: aws.String("owner"), Value: aws.String("ops")},
+			}},
+			old:    old,
+			images: map[string]string{"app": "app:1", "proxy": "proxy:1"},
+			memory: map[string]int64{"app": 512},
+			tags:   map[string]string{"owner": "ops"},
+			cpu:    "256",
+		},
+		{
+			name: "has no previous revision",
+			td: TaskDefinition{Family: "web", Cpu: aws.String("256"), ContainerDefinitions: []*ContainerDefinition{
+				{Name: "app", Image: aws.String("app:1")},
+			}},
+			images: map[string]string{"app": "app:1"},
+			memory: map[string]int64{},
+			tags:   map[string]string{},
+			cpu:    "256",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var tags []*ecs.Tag
+			if tt.old != nil {
+				tags = oldTags
+			}
+			input := tt.td.generateInput(tt.old, tags)
+
+			if aws.StringValue(input.Family) != "web" {
+				t.Errorf("Family = %q, want %q", aws.StringValue(input.Family), "web")
+			}
+			if aws.StringValue(input.Cpu) != tt.cpu {
+				t.Errorf("Cpu = %q, want %q", aws.StringValue(input.Cpu), tt.cpu)
+			}
+
+			images := map[string]string{}
+			memory := map[string]int64{}
+			for _, cd := range input.ContainerDefinitions {
+				images[*cd.Name] = aws.StringValue(cd.Image)
+				if cd.Memory != nil {
+					memory[*cd.Name] = *cd.Memory
+				}
+			}
+			if !reflect.DeepEqual(images, tt.images) {
+				t.Errorf("images = %v, want %v", images, tt.images)
+			}
+			if !reflect.DeepEqual(memory, tt.memory) {
+				t.Errorf("memory = %v, want %v", memory, tt.memory)
+			}
+			if got := tagMap(input.Tags); !reflect.DeepEqual(got, tt.tags) {
+				t.Errorf("tags = %v, want %v", got, tt.tags)
+			}
+		})
+	}
+}
+
+func TestMergeContainerDefinitionsOrder(t *testing.T) {
+	td := TaskDefinition{Family: "web", ContainerDefinitions: []*ContainerDefinition{
+		{Name: "worker", Image: aws.String("worker:1")},
+		{Name: "proxy", Image: aws.String("proxy:2")},
+	}}
+	old := []*ecs.ContainerDefinition{
+		{Name: aws.String("app"), Image: aws.String("app:1")},
+		{Name: aws.String("proxy"), Image: aws.String("proxy:1")},
+	}
+
+	got := names(td.mergeContainerDefinitions(old))
+	want := []string{"app", "proxy", "worker"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("containers = %v, want %v", got, want)
+	}
+}
+
+func TestIsValid(t *testing.T) {
+	tests := []struct {
+		name  string
+		td    TaskDefinition
+		valid bool
+	}{
+		{"family", TaskDefinition{Family: "web"}, true},
+		{"family and revision", TaskDefinition{Family: "web:3"}, true},
+		{"no family", TaskDefinition{}, false},
+		{"bad family", TaskDefinition{Family: "web/app"}, false},
+		{"no containers", TaskDefinition{Family: "web", ContainerDefinitions: []*ContainerDefinition{}}, false},
+		{"duplicate containers", TaskDefinition{Family: "web", ContainerDefinitions: []*ContainerDefinition{
+			{Name: "app"}, {Name: "app"},
+		}}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.td.isValid(); (err == nil) != tt.valid {
+				t.Errorf("isValid() = %v, want valid %v", err, tt.valid)
+			}
+		})
+	}
+}
+
+func TestParseFamily(t *testing.T) {
+	tests := []struct {
+		in       string
+		family   string
+		revision string
+		err      bool
+	}{
+		{in: "web", family: "web", revision: "web"},
+		{in: "web:12", family: "web", revision: "web:12"},
+		{in: "arn:aws:ecs:us-east-1:123456789012:task-definition/web:12", family: "web", revision: "web:12"},
+		{in: "arn:aws-cn:ecs:cn-north-1:123456789012:task-definition/web:12", family: "web", revision: "web:12"},
+		{in: "arn:aws-us-gov:ecs:us-gov-west-1:123456789012:task-definition/web:12", family: "web", revision: "web:12"},
+		{in: "arn:aws:ecs:us-east-1:123456789012:task-definition/web", err: true},
+		{in: "web:latest", err: true},
+		{in: "", err: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			family, err := parseFamily(tt.in)
+			if (err != nil) != tt.err {
+				t.Fatalf("parseFamily(%q) error = %v, want error %v", tt.in, err, tt.err)
+			}
+			if family != tt.family {
+				t.Errorf("parseFamily(%q) = %q, want %q", tt.in, family, tt.family)
+			}
+
+			revision, _ := parseFamilyRevision(tt.in)
+			if revision != tt.revision {
+				t.Errorf("parseFamilyRevision(%q) = %q, want %q", tt.in, revision, tt.revision)
+			}
+		})
+	}
+}
+
+func TestRegister(t *testing.T) {
+	f := newFakeECS()
+	f.addService("web", "web:1", 1)
+
+	tests := []struct {
+		name     string
+		td       TaskDefinition
+		revision string
+		image    string
+	}{
+		{"no changes", TaskDefinition{Family: "web"}, "web:1", "web:1"},
+		{"new image", TaskDefinition{Family: "web", ContainerDefinitions: []*ContainerDefinition{
+			{Name: "web", Image: aws.String("web:2")},
+		}}, "web:2", "web:2"},
+		{"dry run", TaskDefinition{Family: "web", DryRun: true, ContainerDefinitions: []*ContainerDefinition{
+			{Name: "web", Image: aws.String("web:3")},
+		}}, "", "web:3"},
+		{"new family", TaskDefinition{Family: "worker", ContainerDefinitions: []*ContainerDefinition{
+			{Name: "worker", Image: aws.String("worker:1")},
+		}}, "worker:1", "worker:1"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			td, err := tt.td.Register(f)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			revision, _ := parseFamilyRevision(aws.StringValue(td.TaskDefinitionArn))
+			if revision != tt.revision {
+				t.Errorf("revision = %q, want %q", revision, tt.revision)
+			}
+			if image := aws.StringValue(td.ContainerDefinitions[0].Image); image != tt.image {
+				t.Errorf("image = %q, want %q", image, tt.image)
+			}
+		})
+	}
+}
+
+func TestRegisterContainerImages(t *testing.T) {
+	f := newFakeECS()
+	base := TaskDefinition{Family: "app", ContainerDefinitions: []*ContainerDefinition{
+		{Name: "app", Image: aws.String("app:1"), Memory: aws.Int64(512)},
+		{Name: "nginx", Image: aws.String("nginx:1")},
+		{Name: "datadog", Image: aws.String("datadog:1")},
+	}}
+	if _, err := base.Register(f); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name   string
+		td     TaskDefinition
+		images []string
+		err    string
+	}{
+		{
+			name:   "updates several containers",
+			td:     TaskDefinition{Family: "app", ContainerImages: map[string]string{"app": "app:2", "nginx": "nginx:2"}},
+			images: []string{"app:2", "nginx:2", "datadog:1"},
+		},
+		{
+			name: "fails on unknown containers",
+			td:   TaskDefinition{Family: "app", ContainerImages: map[string]string{"app": "app:3", "worker": "worker:1"}},
+			err:  "Container [worker] does not exist in Task Definition [app], enable adding containers to add it",
+		},
+		{
+			name:   "adds unknown containers",
+			td:     TaskDefinition{Family: "app", AddContainer: true, ContainerImages: map[string]string{"worker": "worker:1"}},
+			images: []string{"app:2", "nginx:2", "datadog:1", "worker:1"},
+		},
+		{
+			name: "accepts containers of the spec",
+			td: TaskDefinition{Family: "app", ContainerImages: map[string]string{"cron": "cron:2"}, ContainerDefinitions: []*ContainerDefinition{
+				{Name: "cron", Image: aws.String("cron:1")},
+			}},
+			images: []string{"app:2", "nginx:2", "datadog:1", "worker:1", "cron:2"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			td, err := tt.td.Register(f)
+			if tt.err != "" {
+				if err == nil || err.Error() != tt.err {
+					t.Fatalf("Register() = %v, want %q", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			images := []string{}
+			for _, cd := range td.ContainerDefinitions {
+				images = append(images, aws.StringValue(cd.Image))
+			}
+			if !reflect.DeepEqual(images, tt.images) {
+				t.Errorf("images = %v, want %v", images, tt.images)
+			}
+			if memory := aws.Int64Value(td.ContainerDefinitions[0].Memory); memory != 512 {
+				t.Errorf("app memory = %d, want 512", memory)
+			}
+		})
+	}
+}
+
+func TestGenerateInputInheritsNewerFields(t *testing.T) {
+	envFiles := []*ecs.EnvironmentFile{{Type: aws.String(ecs.EnvironmentFileTypeS3), Value: aws.String("arn:aws:s3:::config/web.env")}}
+	old := &ecs.TaskDefinition{
+		Family:                aws.String("web"),
+		EphemeralStorage:      &ecs.EphemeralStorage{SizeInGiB: aws.Int64(50)},
+		RuntimePlatform:       &ecs.RuntimePlatform{CpuArchitecture: aws.String(ecs.CPUArchitectureArm64)},
+		InferenceAccelerators: []*ecs.InferenceAccelerator{{DeviceName: aws.String("device1"), DeviceType: aws.String("eia2.medium")}},
+		ContainerDefinitions: []*ecs.ContainerDefinition{
+			{Name: aws.String("app"), Image: aws.String("app:1"), EnvironmentFiles: envFiles},
+		},
+	}
+
+	td := TaskDefinition{Family: "web", ContainerDefinitions: []*ContainerDefinition{
+		{Name: "app", Image: aws.String("app:2")},
+	}}
+	input := td.generateInput(old, nil)
+
+	if !reflect.DeepEqual(input.EphemeralStorage, old.EphemeralStorage) {
+		t.Errorf("EphemeralStorage = %v, want %v", input.EphemeralStorage, old.EphemeralStorage)
+	}
+	if !reflect.DeepEqual(input.RuntimePlatform, old.RuntimePlatform) {
+		t.Errorf("RuntimePlatform = %v, want %v", input.RuntimePlatform, old.RuntimePlatform)
+	}
+	if !reflect.DeepEqual(input.InferenceAccelerators, old.InferenceAccelerators) {
+		t.Errorf("InferenceAccelerators = %v, want %v", input.InferenceAccelerators, old.InferenceAccelerators)
+	}
+	if got := input.ContainerDefinitions[0].EnvironmentFiles; !reflect.DeepEqual(got, envFiles) {
+		t.Errorf("EnvironmentFiles = %v, want %v", got, envFiles)
+	}
+}
@@ -0,0 +1,178 @@
+package ecs
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/cloudwatchlogs"
+	"github.com/aws/aws-sdk-go/service/cloudwatchlogs/cloudwatchlogsiface"
+	"github.com/aws/aws-sdk-go/service/ecs"
+)
+
+// fakeLogs records the log streams that are read, which hold a single event.
+type fakeLogs struct {
+	cloudwatchlogsiface.CloudWatchLogsAPI
+
+	streams []string
+}
+
+func (f *fakeLogs) GetLogEventsPages(in *cloudwatchlogs.GetLogEventsInput, fn func(*cloudwatchlogs.GetLogEventsOutput, bool) bool) error {
+	f.streams = append(f.streams, *in.LogGroupName+" "+*in.LogStreamName)
+	fn(&cloudwatchlogs.GetLogEventsOutput{Events: []*cloudwatchlogs.OutputLogEvent{
+		{Timestamp: aws.Int64(0), Message: aws.String("migrated")},
+	}}, true)
+
+	return nil
+}
+
+func TestRunTask(t *testing.T) {
+	tests := []struct {
+		name      string
+		exitCodes map[string]int64
+		failures  []*ecs.Failure
+		task      Task
+		code      int64
+		overrides *ecs.TaskOverride
+		err       string
+	}{
+		{
+			name:      "exit code of the essential container",
+			exitCodes: map[string]int64{"sidecar": 0, "migrate": 3},
+			code:      3,
+		},
+		{
+			name:      "exit code of a named container",
+			exitCodes: map[string]int64{"sidecar": 4, "migrate": 0},
+			task:      Task{ContainerName: "sidecar"},
+			code:      4,
+		},
+		{
+			name:      "missing exit code",
+			exitCodes: map[string]int64{"sidecar": 0},
+			err:       "Container [migrate] stopped without an exit code: Essential container in task exited",
+		},
+		{
+			name:     "failures",
+			failures: []*ecs.Failure{{Arn: aws.String("arn:aws:ecs:us-east-1:123456789012:container-instance/1"), Reason: aws.String("RESOURCE:MEMORY")}},
+			err:      "Task [migrate:1] could not be started: RESOURCE:MEMORY (arn:aws:ecs:us-east-1:123456789012:container-instance/1)",
+		},
+		{
+			name:      "command and environment override",
+			exitCodes: map[string]int64{"migrate": 0},
+			task: Task{
+				Command:     aws.StringSlice([]string{"rails", "db:migrate"}),
+				Environment: []*ecs.KeyValuePair{{Name: aws.String("RAILS_ENV"), Value: aws.String("production")}},
+			},
+			overrides: &ecs.TaskOverride{ContainerOverrides: []*ecs.ContainerOverride{{
+				Name:        aws.String("migrate"),
+				Command:     aws.StringSlice([]string{"rails", "db:migrate"}),
+				Environment: []*ecs.KeyValuePair{{Name: aws.String("RAILS_ENV"), Value: aws.String("production")}},
+			}}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := newFakeECS()
+			f.register(&ecs.RegisterTaskDefinitionInput{
+				Family: aws.String("migrate"),
+				ContainerDefinitions: []*ecs.ContainerDefinition{
+					{Name: aws.String("sidecar"), Image: aws.String("envoy:1"), Essential: aws.Bool(false)},
+					{Name: aws.String("migrate"), Image: aws.String("app:1")},
+				},
+			})
+			f.runExitCodes = tt.exitCodes
+			f.runFailures = tt.failures
+
+			task := tt.task
+			task.Cluster = aws.String("default")
+			p := &TaskPlugin{
+				ECS:            f,
+				Logger:         discard,
+				PollInterval:   10 * time.Millisecond,
+				TaskDefinition: TaskDefinition{Family: "migrate"},
+				Task:           task,
+			}
+
+			code, err := p.RunTask(5)
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("RunTask() = %v, want %q", err, tt.err)
+				}
+				if p.Result.Status != StatusFailed {
+					t.Errorf("Status = %s, want %s", p.Result.Status, StatusFailed)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if code != tt.code {
+				t.Errorf("RunTask() = %d, want %d", code, tt.code)
+			}
+			if p.Result.ExitCode == nil || *p.Result.ExitCode != tt.code {
+				t.Errorf("Result.ExitCode = %v, want %d", p.Result.ExitCode, tt.code)
+			}
+			if len(f.runs) != 1 {
+				t.Fatalf("%d RunTask calls, want 1", len(f.runs))
+			}
+			if !reflect.DeepEqual(f.runs[0].Overrides, tt.overrides) {
+				t.Errorf("Overrides = %v, want %v", f.runs[0].Overrides, tt.overrides)
+			}
+		})
+	}
+}
+
+func TestRunTaskLogs(t *testing.T) {
+	logConfiguration := &ecs.LogConfiguration{
+		LogDriver: aws.String(ecs.LogDriverAwslogs),
+		Options: map[string]*string{
+			"awslogs-group":         aws.String("/ecs/migrate"),
+			"awslogs-stream-prefix": aws.String("app"),
+		},
+	}
+
+	tests := []struct {
+		name    string
+		log     *ecs.LogConfiguration
+		streams []string
+	}{
+		{name: "awslogs", log: logConfiguration, streams: []string{"/ecs/migrate app/migrate/1"}},
+		{name: "no prefix", log: &ecs.LogConfiguration{LogDriver: aws.String(ecs.LogDriverAwslogs), Options: map[string]*string{"awslogs-group": aws.String("/ecs/migrate")}}},
+		{name: "other driver", log: &ecs.LogConfiguration{LogDriver: aws.String(ecs.LogDriverJsonFile)}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := newFakeECS()
+			f.register(&ecs.RegisterTaskDefinitionInput{
+				Family: aws.String("migrate"),
+				ContainerDefinitions: []*ecs.ContainerDefinition{
+					{Name: aws.String("migrate"), Image: aws.String("app:1"), LogConfiguration: tt.log},
+				},
+			})
+			f.runExitCodes = map[string]int64{"migrate": 0}
+
+			logs := &fakeLogs{}
+			p := &TaskPlugin{
+				ECS:            f,
+				Logs:           logs,
+				Logger:         discard,
+				PollInterval:   10 * time.Millisecond,
+				TaskDefinition: TaskDefinition{Family: "migrate"},
+				Task:           Task{Cluster: aws.String("default")},
+			}
+
+			if _, err := p.RunTask(5); err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(logs.streams, tt.streams) {
+				t.Errorf("streams = %v, want %v", logs.streams, tt.streams)
+			}
+		})
+	}
+}
@@ -0,0 +1,289 @@
+package ecs
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ecs"
+	"github.com/aws/aws-sdk-go/service/ssm"
+	"github.com/aws/aws-sdk-go/service/ssm/ssmiface"
+
+	cred "github.com/carash/ecs-deploy/credential"
+)
+
+// newSSM is only called when a container reads its secrets from a Parameter
+// Store path.
+func newSSM(c cred.Credential, svc ssmiface.SSMAPI) (ssmiface.SSMAPI, error) {
+	if svc != nil {
+		return svc, nil
+	}
+
+	sess, err := c.NewSession()
+	if err != nil {
+		return nil, err
+	}
+
+	return ssm.New(sess), nil
+}
+
+// loadVariables reads the EnvironmentFile and SecretsPath of each container.
+func (td *TaskDefinition) loadVariables(ctx aws.Context, c cred.Credential, svc ssmiface.SSMAPI) error {
+	for _, cd := range td.ContainerDefinitions {
+		if cd == nil {
+			continue
+		}
+
+		if cd.EnvironmentFile != "" {
+			env, err := readDotenv(cd.EnvironmentFile)
+			if err != nil {
+				return err
+			}
+			cd.loadedEnvironment = env
+		}
+
+		if cd.SecretsPath != "" {
+			client, err := newSSM(c, svc)
+			if err != nil {
+				return err
+			}
+			secrets, err := secretsFromPath(ctx, client, cd.SecretsPath)
+			if err != nil {
+				return err
+			}
+			svc = client
+			cd.loadedSecrets = secrets
+		}
+	}
+
+	return nil
+}
+
+// readDotenv reads KEY=VALUE lines, skipping blank lines and comments. An
+// `export` prefix and quotes around the value are removed.
+func readDotenv(path string) ([]*ecs.KeyValuePair, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	env := []*ecs.KeyValuePair{}
+	scanner := bufio.NewScanner(f)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		kv := strings.SplitN(strings.TrimPrefix(line, "export "), "=", 2)
+		key := strings.TrimSpace(kv[0])
+		if len(kv) != 2 || key == "" {
+			return nil, fmt.Errorf("Environment file [%s] line %d must be KEY=VALUE", path, n)
+		}
+
+		value := strings.TrimSpace(kv[1])
+		if len(value) >= 2 && value[0] == '"' && value[len(value)-1] == '"' {
+			value = strings.NewReplacer(`\n`, "\n", `\"`, `"`, `\\`, `\`).Replace(value[1 : len(value)-1])
+		} else if len(value) >= 2 && value[0] == '\'' && value[len(value)-1] == '\'' {
+			value = value[1 : len(value)-1]
+		}
+
+		env = append(env, &ecs.KeyValuePair{Name: aws.String(key), Value: aws.String(value)})
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return env, nil
+}
+
+// secretsFromPath turns the parameters under path into Secrets, named after
+// the rest of the parameter name with slashes replaced by underscores.
+func secretsFromPath(ctx aws.Context, svc ssmiface.SSMAPI, path string) ([]*ecs.Secret, error) {
+	prefix := "/" + strings.Trim(path, "/")
+
+	secrets := []*ecs.Secret{}
+	err := svc.GetParametersByPathPagesWithContext(ctx, &ssm.GetParametersByPathInput{
+		Path:      aws.String(prefix),
+		Recursive: aws.Bool(true),
+	}, func(out *ssm.GetParametersByPathOutput, last bool) bool {
+		for _, p := range out.Parameters {
+			name := strings.Trim(strings.TrimPrefix(aws.StringValue(p.Name), prefix), "/")
+			secrets = append(secrets, &ecs.Secret{
+				Name:      aws.String(strings.Replace(name, "/", "_", -1)),
+				ValueFrom: p.ARN,
+			})
+		}
+		return true
+	})
+	if err != nil {
+		return nil, fmt.Errorf("Parameters of [%s] cannot be read: %s", prefix, err)
+	}
+	if len(secrets) == 0 {
+		return nil, fmt.Errorf("No parameters were found in [%s]", prefix)
+	}
+
+	return secrets, nil
+}
+
+// patchEnvironment merges the loaded and MergeEnvironment entries into env
+// by name, then removes UnsetEnvironment. env itself is not modified.
+func (cd *ContainerDefinition) patchEnvironment(env []*ecs.KeyValuePair) []*ecs.KeyValuePair {
+	merge := append(append([]*ecs.KeyValuePair{}, cd.loadedEnvironment...), cd.MergeEnvironment...)
+	if len(merge) == 0 && len(cd.UnsetEnvironment) == 0 {
+		return env
+	}
+
+	patched := append([]*ecs.KeyValuePair{}, env...)
+	for _, m := range merge {
+		replaced := false
+		for i, e := range patched {
+			if e != nil && aws.StringValue(e.Name) == aws.StringValue(m.Name) {
+				patched[i] = m
+				replaced = true
+			}
+		}
+		if !replaced {
+			patched = append(patched, m)
+		}
+	}
+
+	unset := map[string]bool{}
+	for _, name := range cd.UnsetEnvironment {
+		unset[name] = true
+	}
+	environment := []*ecs.KeyValuePair{}
+	for _, e := range patched {
+		if e == nil || !unset[aws.StringValue(e.Name)] {
+			environment = append(environment, e)
+		}
+	}
+
+	return environment
+}
+
+// patchSecrets is patchEnvironment for Secrets.
+func (cd *ContainerDefinition) patchSecrets(secrets []*ecs.Secret) []*ecs.Secret {
+	merge := append(append([]*ecs.Secret{}, cd.loadedSecrets...), cd.MergeSecrets...)
+	if len(merge) == 0 && len(cd.UnsetSecrets) == 0 {
+		return secrets
+	}
+
+	patched := append([]*ecs.Secret{}, secrets...)
+	for _, m := range merge {
+		replaced := false
+		for i, s := range patched {
+			if s != nil && aws.StringValue(s.Name) == aws.StringValue(m.Name) {
+				patched[i] = m
+				replaced = true
+			}
+		}
+		if !replaced {
+			patched = append(patched, m)
+		}
+	}
+
+	unset := map[string]bool{}
+	for _, name := range cd.UnsetSecrets {
+		unset[name] = true
+	}
+	result := []*ecs.Secret{}
+	for _, s := range patched {
+		if s == nil || !unset[aws.StringValue(s.Name)] {
+			result = append(result, s)
+		}
+	}
+
+	return result
+}
+
+// checkVariables rejects duplicate names within each list of the spec, and
+// names that are both merged and unset.
+func (cd *ContainerDefinition) checkVariables() error {
+	lists := []struct {
+		field string
+		names []string
+	}{
+		{"Environment", environmentNames(cd.Environment)},
+		{"MergeEnvironment", environmentNames(cd.MergeEnvironment)},
+		{"UnsetEnvironment", cd.UnsetEnvironment},
+		{"Secrets", secretNames(cd.Secrets)},
+		{"MergeSecrets", secretNames(cd.MergeSecrets)},
+		{"UnsetSecrets", cd.UnsetSecrets},
+	}
+	for _, l := range lists {
+		if name, ok := duplicate(l.names); ok {
+			return fmt.Errorf("Container [%s] has duplicate %s [%s]", cd.Name, l.field, name)
+		}
+	}
+
+	if name, ok := duplicate(append(environmentNames(cd.MergeEnvironment), cd.UnsetEnvironment...)); ok {
+		return fmt.Errorf("Container [%s] both merges and unsets Environment [%s]", cd.Name, name)
+	}
+	if name, ok := duplicate(append(secretNames(cd.MergeSecrets), cd.UnsetSecrets...)); ok {
+		return fmt.Errorf("Container [%s] both merges and unsets Secret [%s]", cd.Name, name)
+	}
+
+	return nil
+}
+
+// checkContainerVariables rejects registering a container with the same
+// variable twice, in its Environment, its Secrets, or across both.
+func checkContainerVariables(cds []*ecs.ContainerDefinition) error {
+	for _, cd := range cds {
+		if cd == nil {
+			continue
+		}
+
+		env := environmentNames(cd.Environment)
+		if name, ok := duplicate(env); ok {
+			return fmt.Errorf("Container [%s] has duplicate Environment [%s]", aws.StringValue(cd.Name), name)
+		}
+		secrets := secretNames(cd.Secrets)
+		if name, ok := duplicate(secrets); ok {
+			return fmt.Errorf("Container [%s] has duplicate Secret [%s]", aws.StringValue(cd.Name), name)
+		}
+		if name, ok := duplicate(append(env, secrets...)); ok {
+			return fmt.Errorf("Container [%s] has [%s] as both Environment and Secret", aws.StringValue(cd.Name), name)
+		}
+	}
+
+	return nil
+}
+
+func environmentNames(env []*ecs.KeyValuePair) []string {
+	names := []string{}
+	for _, e := range env {
+		if e != nil {
+			names = append(names, aws.StringValue(e.Name))
+		}
+	}
+
+	return names
+}
+
+func secretNames(secrets []*ecs.Secret) []string {
+	names := []string{}
+	for _, s := range secrets {
+		if s != nil {
+			names = append(names, aws.StringValue(s.Name))
+		}
+	}
+
+	return names
+}
+
+func duplicate(names []string) (string, bool) {
+	seen := map[string]bool{}
+	for _, name := range names {
+		if seen[name] {
+			return name, true
+		}
+		seen[name] = true
+	}
+
+	return "", false
+}
@@ -0,0 +1,211 @@
+package ecs
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/ecs"
+	"github.com/aws/aws-sdk-go/service/ssm"
+	"github.com/aws/aws-sdk-go/service/ssm/ssmiface"
+
+	cred "github.com/carash/ecs-deploy/credential"
+)
+
+func pairs(env []*ecs.KeyValuePair) []string {
+	ps := []string{}
+	for _, e := range env {
+		ps = append(ps, aws.StringValue(e.Name)+"="+aws.StringValue(e.Value))
+	}
+
+	return ps
+}
+
+func TestPatchVariables(t *testing.T) {
+	old := &ecs.ContainerDefinition{
+		Name: aws.String("app"),
+		Environment: []*ecs.KeyValuePair{
+			{Name: aws.String("A"), Value: aws.String("1")},
+			{Name: aws.String("B"), Value: aws.String("2")},
+		},
+		Secrets: []*ecs.Secret{
+			{Name: aws.String("TOKEN"), ValueFrom: aws.String("arn:token")},
+		},
+	}
+
+	tests := []struct {
+		name    string
+		cd      ContainerDefinition
+		env     []string
+		secrets []string
+		err     string
+	}{
+		{
+			name:    "inherits",
+			cd:      ContainerDefinition{Name: "app"},
+			env:     []string{"A=1", "B=2"},
+			secrets: []string{"TOKEN"},
+		},
+		{
+			name: "sets the whole list",
+			cd: ContainerDefinition{Name: "app", Environment: []*ecs.KeyValuePair{
+				{Name: aws.String("C"), Value: aws.String("3")},
+			}},
+			env:     []string{"C=3"},
+			secrets: []string{"TOKEN"},
+		},
+		{
+			name: "merges and unsets by name",
+			cd: ContainerDefinition{
+				Name: "app",
+				MergeEnvironment: []*ecs.KeyValuePair{
+					{Name: aws.String("B"), Value: aws.String("20")},
+					{Name: aws.String("C"), Value: aws.String("3")},
+				},
+				UnsetEnvironment: []string{"A"},
+				MergeSecrets:     []*ecs.Secret{{Name: aws.String("KEY"), ValueFrom: aws.String("arn:key")}},
+				UnsetSecrets:     []string{"TOKEN"},
+			},
+			env:     []string{"B=20", "C=3"},
+			secrets: []string{"KEY"},
+		},
+		{
+			name: "moves a variable to the secrets",
+			cd: ContainerDefinition{
+				Name:             "app",
+				UnsetEnvironment: []string{"B"},
+				MergeSecrets:     []*ecs.Secret{{Name: aws.String("B"), ValueFrom: aws.String("arn:b")}},
+			},
+			env:     []string{"A=1"},
+			secrets: []string{"TOKEN", "B"},
+		},
+		{
+			name: "duplicate merge",
+			cd: ContainerDefinition{Name: "app", MergeEnvironment: []*ecs.KeyValuePair{
+				{Name: aws.String("B"), Value: aws.String("1")},
+				{Name: aws.String("B"), Value: aws.String("2")},
+			}},
+			err: "Container [app] has duplicate MergeEnvironment [B]",
+		},
+		{
+			name: "merged and unset",
+			cd: ContainerDefinition{
+				Name:         "app",
+				MergeSecrets: []*ecs.Secret{{Name: aws.String("KEY"), ValueFrom: aws.String("arn:key")}},
+				UnsetSecrets: []string{"KEY"},
+			},
+			err: "Container [app] both merges and unsets Secret [KEY]",
+		},
+		{
+			name: "both environment and secret",
+			cd: ContainerDefinition{Name: "app", MergeSecrets: []*ecs.Secret{
+				{Name: aws.String("A"), ValueFrom: aws.String("arn:a")},
+			}},
+			err: "Container [app] has [A] as both Environment and Secret",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.cd.isValid()
+			var cd *ecs.ContainerDefinition
+			if err == nil {
+				cd = tt.cd.generateDefinition(old)
+				err = checkContainerVariables([]*ecs.ContainerDefinition{cd})
+			}
+			if tt.err != "" {
+				if err == nil || err.Error() != tt.err {
+					t.Fatalf("err = %v, want %q", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if env := pairs(cd.Environment); !reflect.DeepEqual(env, tt.env) {
+				t.Errorf("Environment = %v, want %v", env, tt.env)
+			}
+			if secrets := secretNames(cd.Secrets); !reflect.DeepEqual(secrets, tt.secrets) {
+				t.Errorf("Secrets = %v, want %v", secrets, tt.secrets)
+			}
+			if env := pairs(old.Environment); !reflect.DeepEqual(env, []string{"A=1", "B=2"}) {
+				t.Errorf("previous Environment was modified to %v", env)
+			}
+		})
+	}
+}
+
+// fakeSSM serves parameters by name.
+type fakeSSM struct {
+	ssmiface.SSMAPI
+
+	parameters []string
+}
+
+func (f *fakeSSM) GetParametersByPathPagesWithContext(ctx aws.Context, in *ssm.GetParametersByPathInput, fn func(*ssm.GetParametersByPathOutput, bool) bool, opts ...request.Option) error {
+	out := &ssm.GetParametersByPathOutput{}
+	for _, name := range f.parameters {
+		if strings.HasPrefix(name, *in.Path+"/") {
+			out.Parameters = append(out.Parameters, &ssm.Parameter{
+				Name: aws.String(name),
+				ARN:  aws.String("arn:aws:ssm:us-east-1:123456789012:parameter" + name),
+			})
+		}
+	}
+	fn(out, true)
+
+	return nil
+}
+
+func TestLoadVariables(t *testing.T) {
+	dir, err := ioutil.TempDir("", "variables")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	dotenv := filepath.Join(dir, ".env")
+	err = ioutil.WriteFile(dotenv, []byte(strings.Join([]string{
+		"# release settings",
+		"export A=10",
+		`QUOTED="two words"`,
+		"",
+		"SINGLE='$raw'",
+	}, "\n")), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	f := &fakeSSM{parameters: []string{"/web/prod/DB_URL", "/web/prod/api/KEY", "/web/staging/DB_URL"}}
+	td := &TaskDefinition{Family: "web", ContainerDefinitions: []*ContainerDefinition{
+		{Name: "web", EnvironmentFile: dotenv, SecretsPath: "/web/prod/"},
+	}}
+	if err := td.loadVariables(context.Background(), cred.Credential{}, f); err != nil {
+		t.Fatal(err)
+	}
+
+	cd := td.ContainerDefinitions[0].generateDefinition(&ecs.ContainerDefinition{
+		Environment: []*ecs.KeyValuePair{{Name: aws.String("A"), Value: aws.String("1")}},
+	})
+	if env := pairs(cd.Environment); !reflect.DeepEqual(env, []string{"A=10", "QUOTED=two words", "SINGLE=$raw"}) {
+		t.Errorf("Environment = %v", env)
+	}
+	if secrets := secretNames(cd.Secrets); !reflect.DeepEqual(secrets, []string{"DB_URL", "api_KEY"}) {
+		t.Errorf("Secrets = %v", secrets)
+	}
+	if from := aws.StringValue(cd.Secrets[0].ValueFrom); from != "arn:aws:ssm:us-east-1:123456789012:parameter/web/prod/DB_URL" {
+		t.Errorf("ValueFrom = %s", from)
+	}
+
+	td.ContainerDefinitions[0].SecretsPath = "/web/dev"
+	if err := td.loadVariables(context.Background(), cred.Credential{}, f); err == nil {
+		t.Error("loadVariables() found parameters in an empty path")
+	}
+}
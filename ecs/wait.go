@@ -0,0 +1,322 @@
+package ecs
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ecs"
+	"github.com/aws/aws-sdk-go/service/ecs/ecsiface"
+
+	"github.com/carash/ecs-deploy/logger"
+)
+
+// Strategies used by ServicePlugin.UpdateService to decide when a deployment
+// has finished.
+const (
+	// WaitHealthStatus waits for the desired number of tasks running the new
+	// Task Definition to report HEALTHY. It requires container health checks.
+	WaitHealthStatus = "health-status"
+	// WaitDeployment waits for the PRIMARY deployment to reach its desired
+	// count and the older deployments to drain, honoring rolloutState.
+	WaitDeployment = "deployment"
+	// WaitSteadyState waits for the Service to have a single deployment
+	// running its desired count, like the services-stable waiter.
+	WaitSteadyState = "steady-state"
+)
+
+func pollInterval(interval time.Duration) time.Duration {
+	if interval > 0 {
+		return interval
+	}
+
+	return 10 * time.Second
+}
+
+type waitCheck func(ctx aws.Context, svc ecsiface.ECSAPI, service *ecs.Service) (bool, error)
+
+func (p *ServicePlugin) waitCheck() (waitCheck, error) {
+	switch p.WaitStrategy {
+	case "", WaitHealthStatus:
+		return p.checkHealthStatus, nil
+	case WaitDeployment:
+		return p.checkDeployment, nil
+	case WaitSteadyState:
+		return p.checkSteadyState, nil
+	}
+
+	return nil, fmt.Errorf("Unknown wait strategy [%s]", p.WaitStrategy)
+}
+
+// poll calls check right away and then once every interval, until it is
+// done, fails or ctx ends. waiting is called before every retry.
+func poll(ctx aws.Context, interval time.Duration, check func() (bool, error), waiting func()) error {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		done, err := check()
+		if err != nil || done {
+			return err
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+			waiting()
+		}
+	}
+}
+
+// waitError describes why a wait ended early, once its context is done.
+func waitError(ctx aws.Context, start time.Time, waiting string) error {
+	elapsed := int64(time.Now().Sub(start).Seconds())
+	if ctx.Err() == context.DeadlineExceeded {
+		return fmt.Errorf("Timed out after %ds while waiting for %s", elapsed, waiting)
+	}
+
+	return fmt.Errorf("Cancelled after %ds while waiting for %s", elapsed, waiting)
+}
+
+func (p *ServicePlugin) waitForService(ctx aws.Context, svc ecsiface.ECSAPI, service *ecs.Service, timeout int64, since time.Time) error {
+	wait, err := p.waitCheck()
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, time.Duration(timeout)*time.Second)
+	defer cancel()
+
+	start := time.Now()
+	td, _ := parseFamilyRevision(*service.TaskDefinition)
+	watcher := newDeployWatcher(since)
+
+	err = poll(ctx, pollInterval(p.PollInterval), func() (bool, error) {
+		failed, err := p.report(ctx, svc, service, watcher)
+		if err != nil {
+			return false, err
+		}
+		if p.MaxFailedTasks > 0 && failed >= p.MaxFailedTasks {
+			return false, fmt.Errorf("%d tasks of [%s] stopped while deploying", failed, td)
+		}
+
+		return wait(ctx, svc, service)
+	}, func() {
+		p.log().Printf("Waiting for Task [%s] to deploy, %ds...\n", td, int64(time.Now().Sub(start).Seconds()))
+	})
+	if err != nil {
+		if ctx.Err() != nil {
+			return waitError(ctx, start, fmt.Sprintf("Task [%s] to deploy", td))
+		}
+		return err
+	}
+
+	p.log().Printf("Task [%s] is deployed after %d seconds\n\n", td, int64(time.Now().Sub(start).Seconds()))
+	return nil
+}
+
+func (p *TaskPlugin) waitForTask(ctx aws.Context, svc ecsiface.ECSAPI, task *ecs.Task, timeout int64) (*ecs.Task, error) {
+	ctx, cancel := context.WithTimeout(ctx, time.Duration(timeout)*time.Second)
+	defer cancel()
+
+	start := time.Now()
+	id := taskID(task)
+
+	var stopped *ecs.Task
+	err := poll(ctx, pollInterval(p.PollInterval), func() (bool, error) {
+		tasks, err := describeTasks(ctx, svc, p.Task.Cluster, []*string{task.TaskArn})
+		if err != nil {
+			return false, err
+		}
+		if len(tasks) != 1 {
+			return false, fmt.Errorf("Task [%s] was not found", id)
+		}
+
+		p.log().Printf("Status of [%s] -> %s\n", id, aws.StringValue(tasks[0].LastStatus))
+		if aws.StringValue(tasks[0].LastStatus) != ecs.DesiredStatusStopped {
+			return false, nil
+		}
+
+		stopped = tasks[0]
+		return true, nil
+	}, func() {
+		p.log().Printf("Waiting for Task [%s] to stop, %ds...\n", id, int64(time.Now().Sub(start).Seconds()))
+	})
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil, waitError(ctx, start, fmt.Sprintf("Task [%s] to stop", id))
+		}
+		return nil, err
+	}
+
+	printStoppedTask(p.log(), stopped)
+	p.log().Printf("Task [%s] stopped after %d seconds\n\n", id, int64(time.Now().Sub(start).Seconds()))
+	return stopped, nil
+}
+
+func (p *ServicePlugin) checkHealthStatus(ctx aws.Context, svc ecsiface.ECSAPI, service *ecs.Service) (bool, error) {
+	taskArns := []*string{}
+	err := svc.ListTasksPagesWithContext(ctx, &ecs.ListTasksInput{
+		Cluster:       p.Service.Cluster,
+		ServiceName:   &p.Service.Service,
+		DesiredStatus: aws.String(ecs.DesiredStatusRunning),
+	}, func(out *ecs.ListTasksOutput, last bool) bool {
+		taskArns = append(taskArns, out.TaskArns...)
+		return true
+	})
+	if err != nil {
+		return false, err
+	}
+	if len(taskArns) == 0 {
+		return false, nil
+	}
+
+	tasks, err := describeTasks(ctx, svc, p.Service.Cluster, taskArns)
+	if err != nil {
+		return false, err
+	}
+
+	healthy := int64(0)
+	for _, t := range tasks {
+		taskDefinition, _ := parseFamilyRevision(*t.TaskDefinitionArn)
+		p.log().Printf("Status of [%s] -> %s\n", taskDefinition, aws.StringValue(t.HealthStatus))
+
+		if *t.TaskDefinitionArn == *service.TaskDefinition && aws.StringValue(t.HealthStatus) == ecs.HealthStatusHealthy {
+			healthy += 1
+		}
+	}
+	p.log().Printf("\n")
+
+	if int64(len(taskArns)) != *service.DesiredCount {
+		return false, nil
+	}
+
+	return healthy == *service.DesiredCount, nil
+}
+
+func (p *ServicePlugin) checkDeployment(ctx aws.Context, svc ecsiface.ECSAPI, service *ecs.Service) (bool, error) {
+	srv, err := describeService(ctx, svc, p.Service.Cluster, p.Service.Service)
+	if err != nil {
+		return false, err
+	}
+
+	var primary *ecs.Deployment
+	draining := int64(0)
+	for _, d := range srv.Deployments {
+		printDeployment(p.log(), d)
+
+		if aws.StringValue(d.Status) == "PRIMARY" {
+			primary = d
+		} else {
+			draining += aws.Int64Value(d.RunningCount)
+		}
+	}
+	p.log().Printf("\n")
+
+	if primary == nil {
+		return false, nil
+	}
+	if aws.StringValue(primary.TaskDefinition) != *service.TaskDefinition {
+		td, _ := parseFamilyRevision(aws.StringValue(primary.TaskDefinition))
+		return false, fmt.Errorf("Deployment was replaced by Task [%s]", td)
+	}
+
+	switch aws.StringValue(primary.RolloutState) {
+	case ecs.DeploymentRolloutStateCompleted:
+		return true, nil
+	case ecs.DeploymentRolloutStateFailed:
+		return false, fmt.Errorf("Deployment [%s] failed: %s", aws.StringValue(primary.Id), aws.StringValue(primary.RolloutStateReason))
+	case "":
+		return aws.Int64Value(primary.RunningCount) == aws.Int64Value(primary.DesiredCount) && draining == 0, nil
+	}
+
+	return false, nil
+}
+
+func (p *ServicePlugin) checkSteadyState(ctx aws.Context, svc ecsiface.ECSAPI, service *ecs.Service) (bool, error) {
+	srv, err := describeService(ctx, svc, p.Service.Cluster, p.Service.Service)
+	if err != nil {
+		return false, err
+	}
+
+	for _, d := range srv.Deployments {
+		printDeployment(p.log(), d)
+	}
+	p.log().Printf("\n")
+
+	if len(srv.Deployments) != 1 {
+		return false, nil
+	}
+	if aws.StringValue(srv.Deployments[0].TaskDefinition) != *service.TaskDefinition {
+		td, _ := parseFamilyRevision(aws.StringValue(srv.Deployments[0].TaskDefinition))
+		return false, fmt.Errorf("Deployment was replaced by Task [%s]", td)
+	}
+
+	return aws.Int64Value(srv.RunningCount) == aws.Int64Value(srv.DesiredCount), nil
+}
+
+func printDeployment(log logger.Logger, d *ecs.Deployment) {
+	td, _ := parseFamilyRevision(aws.StringValue(d.TaskDefinition))
+	line := fmt.Sprintf("Deployment of [%s] %s -> %d/%d running, %d pending",
+		td, aws.StringValue(d.Status), aws.Int64Value(d.RunningCount), aws.Int64Value(d.DesiredCount), aws.Int64Value(d.PendingCount))
+	if d.RolloutState != nil {
+		line += fmt.Sprintf(", %s", *d.RolloutState)
+	}
+	log.Printf("%s\n", line)
+}
+
+func describeService(ctx aws.Context, svc ecsiface.ECSAPI, cluster *string, service string) (*ecs.Service, error) {
+	srv, err := findService(ctx, svc, cluster, service)
+	if err != nil {
+		return nil, err
+	}
+
+	if srv == nil {
+		return nil, fmt.Errorf("Cluster/Service combination not found")
+	}
+
+	return srv, nil
+}
+
+// findService returns nil when the Service does not exist or was deleted.
+func findService(ctx aws.Context, svc ecsiface.ECSAPI, cluster *string, service string) (*ecs.Service, error) {
+	srvout, err := svc.DescribeServicesWithContext(ctx, &ecs.DescribeServicesInput{
+		Cluster:  cluster,
+		Services: []*string{&service},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	for _, srv := range srvout.Services {
+		if aws.StringValue(srv.Status) != "INACTIVE" {
+			return srv, nil
+		}
+	}
+
+	return nil, nil
+}
+
+// describeTasks works around the limit of 100 tasks per DescribeTasks call.
+func describeTasks(ctx aws.Context, svc ecsiface.ECSAPI, cluster *string, taskArns []*string) ([]*ecs.Task, error) {
+	tasks := []*ecs.Task{}
+	for i := 0; i < len(taskArns); i += 100 {
+		j := i + 100
+		if j > len(taskArns) {
+			j = len(taskArns)
+		}
+
+		detout, err := svc.DescribeTasksWithContext(ctx, &ecs.DescribeTasksInput{
+			Cluster: cluster,
+			Tasks:   taskArns[i:j],
+		})
+		if err != nil {
+			return nil, err
+		}
+		tasks = append(tasks, detout.Tasks...)
+	}
+
+	return tasks, nil
+}
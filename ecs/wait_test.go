@@ -0,0 +1,209 @@
+package ecs
+
+import (
+	"context"
+	"io/ioutil"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+
+	"github.com/carash/ecs-deploy/logger"
+)
+
+var discard = logger.NewText(ioutil.Discard)
+
+func TestUpdateService(t *testing.T) {
+	tests := []struct {
+		name        string
+		strategy    string
+		healthCheck bool
+		crashing    bool
+		maxFailed   int64
+		timeout     int64
+		err         string
+		revision    string
+	}{
+		{name: "health-status", strategy: WaitHealthStatus, healthCheck: true, timeout: 5, revision: "web:2"},
+		{name: "deployment", strategy: WaitDeployment, healthCheck: true, timeout: 5, revision: "web:2"},
+		{name: "steady-state", strategy: WaitSteadyState, healthCheck: true, timeout: 5, revision: "web:2"},
+		{name: "health-status without health check", strategy: WaitHealthStatus, timeout: 1, err: "Timed out", revision: "web:2"},
+		{name: "deployment without health check", strategy: WaitDeployment, timeout: 5, revision: "web:2"},
+		{name: "steady-state without health check", strategy: WaitSteadyState, timeout: 5, revision: "web:2"},
+		{name: "crashing times out", strategy: WaitDeployment, healthCheck: true, crashing: true, timeout: 1, err: "Timed out", revision: "web:2"},
+		{name: "crashing fails fast", strategy: WaitDeployment, healthCheck: true, crashing: true, maxFailed: 2, timeout: 5, err: "2 tasks of [web:2] stopped while deploying", revision: "web:2"},
+		{name: "unknown strategy", strategy: "forever", timeout: 5, err: "Unknown wait strategy [forever]", revision: "web:1"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := newFakeECS()
+			f.addService("web", "web:1", 2)
+			f.healthCheck = tt.healthCheck
+			if tt.crashing {
+				f.crashing["web:2"] = true
+			}
+
+			p := &ServicePlugin{
+				ECS:            f,
+				Logger:         discard,
+				WaitStrategy:   tt.strategy,
+				MaxFailedTasks: tt.maxFailed,
+				PollInterval:   10 * time.Millisecond,
+				Service: Service{
+					Cluster: aws.String("default"),
+					Service: "web",
+					TaskDefinition: &TaskDefinition{Family: "web", ContainerDefinitions: []*ContainerDefinition{
+						{Name: "web", Image: aws.String("web:2")},
+					}},
+				},
+			}
+
+			err := p.UpdateService(tt.timeout)
+			if tt.err == "" && err != nil {
+				t.Fatalf("UpdateService() = %v, want no error", err)
+			}
+			if tt.err != "" && (err == nil || !strings.Contains(err.Error(), tt.err)) {
+				t.Fatalf("UpdateService() = %v, want %q", err, tt.err)
+			}
+			if revision := f.serviceTaskDefinition("web"); revision != tt.revision {
+				t.Errorf("Service runs [%s], want [%s]", revision, tt.revision)
+			}
+
+			status := StatusSucceeded
+			if tt.err != "" {
+				status = StatusFailed
+			}
+			if p.Result.Status != status {
+				t.Errorf("Result.Status = %s, want %s", p.Result.Status, status)
+			}
+			if tt.err == "" && !strings.HasSuffix(p.Result.TaskDefinitionArn, "task-definition/web:2") {
+				t.Errorf("Result.TaskDefinitionArn = %s, want web:2", p.Result.TaskDefinitionArn)
+			}
+			if tt.revision == "web:2" && !strings.HasSuffix(p.Result.PreviousTaskDefinitionArn, "task-definition/web:1") {
+				t.Errorf("Result.PreviousTaskDefinitionArn = %s, want web:1", p.Result.PreviousTaskDefinitionArn)
+			}
+		})
+	}
+}
+
+func TestUpdateServiceRollback(t *testing.T) {
+	f := newFakeECS()
+	f.addService("web", "web:1", 2)
+	f.crashing["web:2"] = true
+
+	p := &ServicePlugin{
+		ECS:               f,
+		Logger:            discard,
+		WaitStrategy:      WaitDeployment,
+		MaxFailedTasks:    1,
+		RollbackOnFailure: true,
+		PollInterval:      10 * time.Millisecond,
+		Service: Service{
+			Cluster: aws.String("default"),
+			Service: "web",
+			TaskDefinition: &TaskDefinition{Family: "web", ContainerDefinitions: []*ContainerDefinition{
+				{Name: "web", Image: aws.String("web:2")},
+			}},
+		},
+	}
+
+	err := p.UpdateService(5)
+	rerr, ok := err.(*RollbackError)
+	if !ok {
+		t.Fatalf("UpdateService() = %v, want a RollbackError", err)
+	}
+	if rerr.TaskDefinition != "web:1" {
+		t.Errorf("rolled back to [%s], want [web:1]", rerr.TaskDefinition)
+	}
+	if revision := f.serviceTaskDefinition("web"); revision != "web:1" {
+		t.Errorf("Service runs [%s], want [web:1]", revision)
+	}
+	if p.Result.Status != StatusRolledBack {
+		t.Errorf("Result.Status = %s, want %s", p.Result.Status, StatusRolledBack)
+	}
+}
+
+func TestUpdateServiceDryRun(t *testing.T) {
+	f := newFakeECS()
+	f.addService("web", "web:1", 2)
+
+	p := &ServicePlugin{
+		ECS:          f,
+		Logger:       discard,
+		PollInterval: 10 * time.Millisecond,
+		Service: Service{
+			DryRun:  true,
+			Cluster: aws.String("default"),
+			Service: "web",
+			TaskDefinition: &TaskDefinition{Family: "web", ContainerDefinitions: []*ContainerDefinition{
+				{Name: "web", Image: aws.String("web:2")},
+			}},
+		},
+	}
+
+	if err := p.UpdateService(5); err != nil {
+		t.Fatal(err)
+	}
+	if revision := f.serviceTaskDefinition("web"); revision != "web:1" {
+		t.Errorf("Service runs [%s], want [web:1]", revision)
+	}
+	if n := len(f.taskDefinitions["web"]); n != 1 {
+		t.Errorf("%d revisions registered, want 1", n)
+	}
+	if p.Result.Status != StatusPlanned {
+		t.Errorf("Result.Status = %s, want %s", p.Result.Status, StatusPlanned)
+	}
+}
+
+func TestUpdateServiceCancel(t *testing.T) {
+	tests := []struct {
+		name     string
+		rollback bool
+		revision string
+	}{
+		{name: "stops waiting", revision: "web:2"},
+		{name: "starts a rollback", rollback: true, revision: "web:1"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := newFakeECS()
+			f.addService("web", "web:1", 2)
+			f.crashing["web:2"] = true
+
+			p := &ServicePlugin{
+				ECS:              f,
+				Logger:           discard,
+				WaitStrategy:     WaitDeployment,
+				RollbackOnCancel: tt.rollback,
+				PollInterval:     10 * time.Millisecond,
+				Service: Service{
+					Cluster: aws.String("default"),
+					Service: "web",
+					TaskDefinition: &TaskDefinition{Family: "web", ContainerDefinitions: []*ContainerDefinition{
+						{Name: "web", Image: aws.String("web:2")},
+					}},
+				},
+			}
+
+			ctx, cancel := context.WithCancel(context.Background())
+			time.AfterFunc(100*time.Millisecond, cancel)
+
+			err := p.UpdateServiceWithContext(ctx, 5)
+			if err == nil || !strings.Contains(err.Error(), "Cancelled after") {
+				t.Fatalf("UpdateServiceWithContext() = %v, want cancelled", err)
+			}
+			if _, ok := err.(*RollbackError); ok != tt.rollback {
+				t.Errorf("UpdateServiceWithContext() = %v, want rollback %v", err, tt.rollback)
+			}
+			if revision := f.serviceTaskDefinition("web"); revision != tt.revision {
+				t.Errorf("Service runs [%s], want [%s]", revision, tt.revision)
+			}
+			if p.Result.Status != StatusCancelled {
+				t.Errorf("Result.Status = %s, want %s", p.Result.Status, StatusCancelled)
+			}
+		})
+	}
+}
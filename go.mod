@@ -3,6 +3,7 @@ module github.com/carash/ecs-deploy
 go 1.13
 
 require (
-	github.com/aws/aws-sdk-go v1.34.0
+	github.com/aws/aws-sdk-go v1.44.210
+	github.com/ghodss/yaml v1.0.0
 	github.com/urfave/cli v1.22.1
 )
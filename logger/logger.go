@@ -0,0 +1,74 @@
+package logger
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Logger receives the progress messages of the plugins.
+type Logger interface {
+	Printf(format string, v ...interface{})
+}
+
+// Default writes plain text to stdout.
+var Default Logger = NewText(os.Stdout)
+
+// OrDefault returns l, or Default when l is nil.
+func OrDefault(l Logger) Logger {
+	if l == nil {
+		return Default
+	}
+
+	return l
+}
+
+type textLogger struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+// NewText returns a Logger writing messages as they are, blank lines
+// included.
+func NewText(w io.Writer) Logger {
+	return &textLogger{w: w}
+}
+
+func (l *textLogger) Printf(format string, v ...interface{}) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	fmt.Fprintf(l.w, format, v...)
+}
+
+type jsonLogger struct {
+	mu  sync.Mutex
+	enc *json.Encoder
+}
+
+type jsonRecord struct {
+	Time    time.Time `json:"time"`
+	Message string    `json:"message"`
+}
+
+// NewJSON returns a Logger writing one JSON object per message. Blank
+// messages, which only space out the text output, are dropped.
+func NewJSON(w io.Writer) Logger {
+	return &jsonLogger{enc: json.NewEncoder(w)}
+}
+
+func (l *jsonLogger) Printf(format string, v ...interface{}) {
+	msg := strings.TrimSpace(fmt.Sprintf(format, v...))
+	if msg == "" {
+		return
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.enc.Encode(jsonRecord{Time: time.Now().UTC(), Message: msg})
+}
@@ -0,0 +1,52 @@
+package logger
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestLogger(t *testing.T) {
+	tests := []struct {
+		name     string
+		format   string
+		args     []interface{}
+		text     string
+		messages []string
+	}{
+		{"message", "Updating Service [%s]...\n", []interface{}{"web"}, "Updating Service [web]...\n", []string{"Updating Service [web]..."}},
+		{"blank line", "\n", nil, "\n", []string{}},
+		{"indented", "  %s\n", []interface{}{"~ Cpu: 256 -> 512"}, "  ~ Cpu: 256 -> 512\n", []string{"~ Cpu: 256 -> 512"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			text := &bytes.Buffer{}
+			NewText(text).Printf(tt.format, tt.args...)
+			if text.String() != tt.text {
+				t.Errorf("text = %q, want %q", text.String(), tt.text)
+			}
+
+			out := &bytes.Buffer{}
+			NewJSON(out).Printf(tt.format, tt.args...)
+			messages := []string{}
+			for _, line := range strings.Split(strings.TrimSpace(out.String()), "\n") {
+				if line == "" {
+					continue
+				}
+				r := jsonRecord{}
+				if err := json.Unmarshal([]byte(line), &r); err != nil {
+					t.Fatalf("line %q is not JSON: %s", line, err)
+				}
+				if r.Time.IsZero() {
+					t.Errorf("line %q has no time", line)
+				}
+				messages = append(messages, r.Message)
+			}
+			if strings.Join(messages, "|") != strings.Join(tt.messages, "|") {
+				t.Errorf("messages = %q, want %q", messages, tt.messages)
+			}
+		})
+	}
+}
@@ -0,0 +1,71 @@
+package output
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+
+	"github.com/carash/ecs-deploy/logger"
+	"github.com/urfave/cli"
+)
+
+// NewLogger picks the log format from the global flags of the commands. The
+// logs move to stderr when the result document is written to stdout, so that
+// stdout stays parseable.
+func NewLogger(c *cli.Context) (logger.Logger, error) {
+	if _, err := IsJSON(c); err != nil {
+		return nil, err
+	}
+
+	w := io.Writer(os.Stdout)
+	if ok, _ := IsJSON(c); ok && c.GlobalString("output-file") == "" {
+		w = os.Stderr
+	}
+
+	switch c.GlobalString("log-format") {
+	case "", "text":
+		return logger.NewText(w), nil
+	case "json":
+		return logger.NewJSON(w), nil
+	}
+
+	return nil, fmt.Errorf("Unknown log format [%s]", c.GlobalString("log-format"))
+}
+
+// IsJSON is true when a result document was asked for, with --output json or
+// an output file.
+func IsJSON(c *cli.Context) (bool, error) {
+	switch c.GlobalString("output") {
+	case "":
+		return c.GlobalString("output-file") != "", nil
+	case "text":
+		return false, nil
+	case "json":
+		return true, nil
+	}
+
+	return false, fmt.Errorf("Unknown output format [%s]", c.GlobalString("output"))
+}
+
+// WriteResult writes the result document to the output file, or to stdout,
+// when JSON output was asked for.
+func WriteResult(c *cli.Context, result interface{}) error {
+	if ok, _ := IsJSON(c); !ok {
+		return nil
+	}
+
+	b, err := json.MarshalIndent(result, "", "  ")
+	if err != nil {
+		return err
+	}
+	b = append(b, '\n')
+
+	if path := c.GlobalString("output-file"); path != "" {
+		return ioutil.WriteFile(path, b, 0644)
+	}
+
+	_, err = os.Stdout.Write(b)
+	return err
+}
@@ -0,0 +1,32 @@
+package signals
+
+import (
+	"context"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/carash/ecs-deploy/logger"
+)
+
+// Context is cancelled when the CI runner interrupts or terminates the
+// build. The returned function stops listening for the signals.
+func Context(out logger.Logger) (context.Context, func()) {
+	ctx, cancel := context.WithCancel(context.Background())
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+
+	go func() {
+		select {
+		case s := <-signals:
+			out.Printf("Received %s, cancelling...\n", s)
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	return ctx, func() {
+		signal.Stop(signals)
+		cancel()
+	}
+}